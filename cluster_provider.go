@@ -0,0 +1,208 @@
+package mk8s
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ClusterProvider discovers clusters - from kubeconfig files, the in-cluster config, GKE,
+// Hub or an inventory service.
+//
+// K8S.init runs the providers in order. The first cluster returned by a provider becomes
+// the Default if none is set - providers return the preferred cluster first. Providers
+// implementing PreferredClusterProvider select the Default explicitly, or none.
+type ClusterProvider interface {
+	// Name identifies the provider - each provider owns the clusters it returned, and
+	// removes them when they are no longer found.
+	Name() string
+
+	// Discover returns the current clusters. Called on init and to refresh - clusters
+	// that didn't change should be returned as the same object, to keep their clients.
+	Discover(ctx context.Context) ([]*K8SCluster, error)
+
+	// Watch calls fn with the new list of clusters each time it changes, until ctx is
+	// done. Providers that can't detect changes return ErrWatchNotSupported - they are
+	// refreshed periodically by K8S.WatchProviders.
+	Watch(ctx context.Context, fn func([]*K8SCluster)) error
+}
+
+// PreferredClusterProvider is implemented by providers that know which of their clusters
+// should be the Default - the current context of a kubeconfig, the in-cluster config.
+type PreferredClusterProvider interface {
+	// Preferred returns the name of the cluster to use as Default, from the last Discover.
+	// If empty, none of the clusters of the provider becomes the Default - a kubeconfig
+	// without current context doesn't pick one of its contexts.
+	Preferred() string
+}
+
+// ErrWatchNotSupported is returned by ClusterProvider.Watch if the provider doesn't detect
+// changes.
+var ErrWatchNotSupported = errors.New("watch not supported")
+
+var (
+	providersMu sync.Mutex
+	providers   []ClusterProvider
+)
+
+// RegisterClusterProvider adds a provider used by New, after the kubeconfig and
+// in-cluster providers.
+func RegisterClusterProvider(p ClusterProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers = append(providers, p)
+}
+
+// defaultProviders returns the providers used if K8S.Providers is not set.
+func defaultProviders() []ClusterProvider {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	return append([]ClusterProvider{&KubeConfigProvider{}, &InClusterProvider{}}, providers...)
+}
+
+// AddProvider adds a provider to the set and loads its clusters.
+func (kr *K8S) AddProvider(ctx context.Context, p ClusterProvider) error {
+	kr.providersMu.Lock()
+	kr.Providers = append(kr.Providers, p)
+	kr.providersMu.Unlock()
+	return kr.refreshProvider(ctx, p)
+}
+
+// Refresh runs Discover for all providers, updating the cluster set. Returns the errors
+// of the failed providers - their clusters are not changed.
+func (kr *K8S) Refresh(ctx context.Context) error {
+	var errs []error
+	for _, p := range kr.providerList() {
+		if err := kr.refreshProvider(ctx, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WatchProviders watches all providers for changes. Providers that don't support Watch
+// are refreshed every interval, if interval is set.
+//
+// Blocks until ctx is done.
+func (kr *K8S) WatchProviders(ctx context.Context, interval time.Duration) {
+	wg := sync.WaitGroup{}
+	for _, p := range kr.providerList() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Watch(ctx, func(cl []*K8SCluster) {
+				kr.syncProvider(p, cl)
+			})
+			if errors.Is(err, ErrWatchNotSupported) && interval > 0 {
+				pollProvider(ctx, interval, func() {
+					if err := kr.refreshProvider(ctx, p); err != nil {
+						logger.Warn("ClusterProviderRefreshFailed", "provider", p.Name(), "err", err)
+					}
+				})
+			} else if err != nil && ctx.Err() == nil {
+				logger.Warn("ClusterProviderWatchFailed", "provider", p.Name(), "err", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (kr *K8S) providerList() []ClusterProvider {
+	kr.providersMu.Lock()
+	defer kr.providersMu.Unlock()
+	return append([]ClusterProvider{}, kr.Providers...)
+}
+
+func (kr *K8S) refreshProvider(ctx context.Context, p ClusterProvider) error {
+	cl, err := p.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	kr.syncProvider(p, cl)
+	return nil
+}
+
+// syncProvider adds the clusters returned by the provider, and removes the clusters it
+// returned before and are now missing. The changes are computed with providersMu held,
+// and applied after releasing it - subscribers may call back into the set.
+//
+// A provider only replaces the clusters it returned before - if a cluster with the same
+// name was added by an earlier provider or by other sources, the first one is kept.
+//
+// If no Default is set, the preferred cluster of the provider becomes the Default. The
+// Default is replaced if it was changed or removed.
+func (kr *K8S) syncProvider(p ClusterProvider, cl []*K8SCluster) {
+	name := p.Name()
+	cur := map[string]*K8SCluster{}
+	owned := make([]*K8SCluster, 0, len(cl))
+	kr.providersMu.Lock()
+	if kr.providerClusters == nil {
+		kr.providerClusters = map[string]map[string]*K8SCluster{}
+	}
+	prev := kr.providerClusters[name]
+	for _, c := range cl {
+		if old := kr.Cluster(c.Name); old != nil && old != c && (prev[c.Name] == nil || old != prev[c.Name]) {
+			logger.Warn("DuplicateClusterName", "cluster", c.Name, "provider", name, "used", old.Source)
+			continue
+		}
+		cur[c.Name] = c
+		owned = append(owned, c)
+	}
+	kr.providerClusters[name] = cur
+	kr.providersMu.Unlock()
+
+	preferred := ""
+	if pp, ok := p.(PreferredClusterProvider); ok {
+		preferred = pp.Preferred()
+	} else if len(cl) > 0 {
+		preferred = cl[0].Name
+	}
+
+	for _, c := range owned {
+		// Set before notifying the subscribers.
+		if def := kr.DefaultCluster(); (def == nil && c.Name == preferred) ||
+			(def != nil && def != c && def.Name == c.Name && def == prev[c.Name]) {
+			kr.setDefault(def, c)
+		}
+		if kr.Cluster(c.Name) == c {
+			continue
+		}
+		if c.RestConfig != nil {
+			kr.setRateLimits(c.RestConfig)
+		}
+		if prev[c.Name] != nil {
+			kr.AddCluster(c, true)
+		} else if !kr.AddCluster(c, false) {
+			// Added concurrently by another source.
+			logger.Warn("DuplicateClusterName", "cluster", c.Name, "provider", name)
+			kr.setDefault(c, kr.Cluster(c.Name))
+		}
+	}
+
+	for n, c := range prev {
+		if cur[n] != nil || kr.Cluster(n) != c {
+			// Still found, or replaced by another source.
+			continue
+		}
+		// Otherwise RemoveCluster picks the preferred remaining cluster.
+		if pc := cur[preferred]; pc != nil {
+			kr.setDefault(c, pc)
+		}
+		kr.RemoveCluster(n)
+	}
+}
+
+// pollProvider calls fn every interval until ctx is done.
+func pollProvider(ctx context.Context, interval time.Duration, fn func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			fn()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
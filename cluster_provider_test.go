@@ -0,0 +1,218 @@
+package mk8s
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// testProvider returns a fixed list of clusters, and sends updates to Watch.
+type testProvider struct {
+	mu       sync.Mutex
+	clusters []*K8SCluster
+	updates  chan []*K8SCluster
+}
+
+func (p *testProvider) Name() string {
+	return "test"
+}
+
+func (p *testProvider) Discover(ctx context.Context) ([]*K8SCluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.clusters, nil
+}
+
+func (p *testProvider) Watch(ctx context.Context, fn func([]*K8SCluster)) error {
+	for {
+		select {
+		case cl := <-p.updates:
+			fn(cl)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func testCluster(name string) *K8SCluster {
+	return &K8SCluster{Name: name, RestConfig: &rest.Config{Host: "https://" + name + ".example.com"}}
+}
+
+func TestClusterProvider(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	a, b, c := testCluster("a"), testCluster("b"), testCluster("c")
+	p := &testProvider{clusters: []*K8SCluster{a, b}, updates: make(chan []*K8SCluster)}
+	k := &K8S{Providers: []ClusterProvider{p}}
+	if err := k.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if k.Default != a || len(k.Clusters()) != 2 {
+		t.Fatal("Unexpected clusters", k.Clusters(), k.Default)
+	}
+
+	// Clusters added by others are not removed.
+	k.AddCluster(c, true)
+	p.clusters = []*K8SCluster{b}
+	if err := k.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if k.Cluster("a") != nil || k.Cluster("c") != c || k.Default != b {
+		t.Fatal("Unexpected clusters after refresh", k.Clusters(), k.Default)
+	}
+
+	events := make(chan ClusterEvent, 10)
+	k.Subscribe(func(ev ClusterEvent) {
+		events <- ev
+	})
+	go k.WatchProviders(ctx, 0)
+
+	a2 := testCluster("a")
+	p.updates <- []*K8SCluster{b, a2}
+	select {
+	case ev := <-events:
+		if ev.Type != ClusterAdded || ev.Cluster != a2 {
+			t.Error("Unexpected event", ev)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timeout")
+	}
+}
+
+func TestDirProvider(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.yaml"), []byte(testKubeconfigContexts("a", "a", "x")), 0600)
+	os.WriteFile(filepath.Join(dir, "b.yaml"), []byte(testKubeconfigContexts("b", "b")), 0600)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte("invalid"), 0600)
+	// Same cluster name as b.yaml - ignored.
+	os.WriteFile(filepath.Join(dir, "b.yml"), []byte(testKubeconfigContexts("c", "c")), 0600)
+
+	k := &K8S{}
+	p := &DirProvider{Dir: dir, Interval: 10 * time.Millisecond}
+	if err := k.AddProvider(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	a, b := k.Cluster("a"), k.Cluster("b")
+	if len(k.Clusters()) != 2 || a == nil || b == nil || k.Default != a {
+		t.Fatal("Unexpected clusters", k.Clusters())
+	}
+	if a.Source != filepath.Join(dir, "a.yaml") || b.RestConfig.Host != "https://b.example.com" {
+		t.Error("Unexpected cluster", a.Source, b.RestConfig.Host)
+	}
+
+	events := make(chan ClusterEvent, 10)
+	k.Subscribe(func(ev ClusterEvent) {
+		events <- ev
+	})
+	go k.WatchProviders(ctx, 0)
+
+	// b changed, a unchanged.
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(dir, "b.yaml"), []byte(strings.ReplaceAll(testKubeconfigContexts("b", "b"),
+		"b.example.com", "b2.example.com")), 0600)
+	select {
+	case ev := <-events:
+		if ev.Type != ClusterChanged || ev.Cluster.Name != "b" || ev.Cluster.RestConfig.Host != "https://b2.example.com" {
+			t.Error("Unexpected event", ev)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timeout")
+	}
+	if k.Cluster("a") != a {
+		t.Error("Unchanged cluster replaced")
+	}
+
+	os.Remove(filepath.Join(dir, "a.yaml"))
+	select {
+	case ev := <-events:
+		if ev.Type != ClusterRemoved || ev.Cluster != a {
+			t.Error("Unexpected event", ev)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timeout")
+	}
+	if k.Default == nil || k.Default.Name != "b" {
+		t.Error("Default not replaced", k.Default)
+	}
+}
+
+func TestProviderDefault(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// The current context is the Default, not the first context.
+	kcf := filepath.Join(dir, "config")
+	os.WriteFile(kcf, []byte(testKubeconfigContexts("b", "a", "b")), 0600)
+	k := &K8S{Providers: []ClusterProvider{&KubeConfigProvider{Path: kcf}}}
+	if err := k.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if def := k.DefaultCluster(); def == nil || def.Name != "b" {
+		t.Fatal("Expected current context as Default", def)
+	}
+
+	// Without current context, the next provider - normally in-cluster - is the Default.
+	os.WriteFile(kcf, []byte(testKubeconfigContexts("", "a", "b")), 0600)
+	ic := testCluster("incluster")
+	k = &K8S{Providers: []ClusterProvider{&KubeConfigProvider{Path: kcf},
+		&testProvider{clusters: []*K8SCluster{ic}}}}
+
+	// Subscribers can use the set while the providers are synced.
+	k.Subscribe(func(ev ClusterEvent) {
+		k.providerList()
+	})
+	if err := k.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if def := k.DefaultCluster(); def != ic || len(k.Clusters()) != 3 {
+		t.Fatal("Expected in-cluster as Default", def, k.Clusters())
+	}
+}
+
+func TestProviderDuplicate(t *testing.T) {
+	ctx := context.Background()
+
+	// The first provider owns the name, later providers don't replace it.
+	a, a2, b := testCluster("a"), testCluster("a"), testCluster("b")
+	p1 := &testProvider{clusters: []*K8SCluster{a}}
+	k := &K8S{Providers: []ClusterProvider{p1, &namedProvider{&testProvider{clusters: []*K8SCluster{a2, b}}, "p2"}}}
+	events := 0
+	k.Subscribe(func(ev ClusterEvent) {
+		events++
+	})
+	if err := k.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if k.Cluster("a") != a || k.DefaultCluster() != a || k.Cluster("b") != b || events != 2 {
+		t.Fatal("Unexpected clusters", k.Clusters(), k.DefaultCluster(), events)
+	}
+	if err := k.Refresh(ctx); err != nil || k.Cluster("a") != a || events != 2 {
+		t.Fatal("Cluster replaced on refresh", err, events)
+	}
+
+	// Once the owner drops it, the next provider can add it.
+	p1.clusters = nil
+	if err := k.Refresh(ctx); err != nil || k.Cluster("a") != a2 {
+		t.Fatal("Cluster not added", err, k.Cluster("a"))
+	}
+}
+
+// namedProvider renames a provider, to use the same implementation twice.
+type namedProvider struct {
+	ClusterProvider
+	name string
+}
+
+func (p *namedProvider) Name() string {
+	return p.name
+}
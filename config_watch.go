@@ -0,0 +1,177 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultDebounce is the delay used to merge changes in WatchConfigMaps and WatchSecrets.
+const DefaultDebounce = 100 * time.Millisecond
+
+var configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// DataEvent is the state of a watched ConfigMap or Secret.
+type DataEvent[V string | []byte] struct {
+	Namespace string
+	Name      string
+
+	// Data is the decoded data of the object - nil if it doesn't exist.
+	Data map[string]V
+
+	// Labels of the object - used with label selectors.
+	Labels map[string]string
+
+	ResourceVersion string
+
+	// Exists is false if the object was deleted or never existed.
+	Exists bool
+
+	// Deleted is set if the object existed and was deleted. A watch for a single object
+	// that doesn't exist gets an event with Exists and Deleted false.
+	Deleted bool
+}
+
+// ConfigWatchOptions selects the ConfigMaps or Secrets to watch - a single object by Name,
+// or the objects matching LabelSelector.
+type ConfigWatchOptions struct {
+	Name          string
+	LabelSelector string
+
+	// Debounce is the delay before sending changes - changes to the same object in this
+	// interval are merged and only the last state is sent. Defaults to DefaultDebounce.
+	Debounce time.Duration
+
+	// Backoff is used when the watch fails. Defaults to 1s, doubling up to 5 min.
+	Backoff *wait.Backoff
+}
+
+// WatchConfigMaps calls fn with the data of the selected ConfigMaps when they change.
+// The initial state is sent first - including an event with Exists false if a named
+// ConfigMap doesn't exist.
+//
+// fn is called from the calling goroutine, with the changes sorted by namespace and name.
+// Blocks until ctx is done - watch errors are retried.
+func (kc *K8SCluster) WatchConfigMaps(ctx context.Context, ns string, opts ConfigWatchOptions,
+	fn func([]*DataEvent[string])) error {
+	return watchData(ctx, kc, configMapsGVR, ns, opts, func(raw json.RawMessage) (*DataEvent[string], error) {
+		cm := &v1.ConfigMap{}
+		err := json.Unmarshal(raw, cm)
+		return &DataEvent[string]{Namespace: cm.Namespace, Name: cm.Name, Data: cm.Data,
+			Labels: cm.Labels, ResourceVersion: cm.ResourceVersion}, err
+	}, fn)
+}
+
+// WatchSecrets is like WatchConfigMaps, for Secrets.
+func (kc *K8SCluster) WatchSecrets(ctx context.Context, ns string, opts ConfigWatchOptions,
+	fn func([]*DataEvent[[]byte])) error {
+	return watchData(ctx, kc, secretsGVR, ns, opts, func(raw json.RawMessage) (*DataEvent[[]byte], error) {
+		s := &v1.Secret{}
+		err := json.Unmarshal(raw, s)
+		return &DataEvent[[]byte]{Namespace: s.Namespace, Name: s.Name, Data: s.Data,
+			Labels: s.Labels, ResourceVersion: s.ResourceVersion}, err
+	}, fn)
+}
+
+func watchData[V string | []byte](ctx context.Context, kc *K8SCluster, gvr schema.GroupVersionResource,
+	ns string, opts ConfigWatchOptions, decode func(json.RawMessage) (*DataEvent[V], error),
+	fn func([]*DataEvent[V])) error {
+	wopts := WatchOptions{Namespace: ns, LabelSelector: opts.LabelSelector, Backoff: opts.Backoff}
+	if opts.Name != "" {
+		wopts.FieldSelector = "metadata.name=" + opts.Name
+	}
+	debounce := opts.Debounce
+	if debounce == 0 {
+		debounce = DefaultDebounce
+	}
+
+	evs := make(chan *DataEvent[V])
+	send := func(ev *DataEvent[V]) error {
+		select {
+		case evs <- ev:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// known has the existing objects by namespace/name, seen the objects changed since the
+	// last initial events bookmark. After a re-list, the known objects not seen were deleted.
+	known := map[string]*DataEvent[V]{}
+	seen := map[string]bool{}
+	synced := false
+	go retryListWatch(ctx, kc, gvr, wopts, func(ev *RawEvent) error {
+		if ev.IsInitialEventsEnd() {
+			for k, de := range known {
+				if !seen[k] {
+					delete(known, k)
+					if err := send(&DataEvent[V]{Namespace: de.Namespace, Name: de.Name, Deleted: true}); err != nil {
+						return err
+					}
+				}
+			}
+			if !synced && opts.Name != "" && len(known) == 0 {
+				if err := send(&DataEvent[V]{Namespace: ns, Name: opts.Name}); err != nil {
+					return err
+				}
+			}
+			synced = true
+			seen = map[string]bool{}
+			return nil
+		}
+		if ev.Type == watch.Bookmark {
+			return nil
+		}
+
+		de, err := decode(ev.Object)
+		if err != nil {
+			logger.Warn("WatchDataInvalid", "cluster", kc.Name, "resource", gvr.Resource, "err", err)
+			return nil
+		}
+		k := de.Namespace + "/" + de.Name
+		if ev.Type == watch.Deleted {
+			delete(known, k)
+			delete(seen, k)
+			return send(&DataEvent[V]{Namespace: de.Namespace, Name: de.Name,
+				ResourceVersion: de.ResourceVersion, Deleted: true})
+		}
+		known[k] = de
+		seen[k] = true
+		de.Exists = true
+		return send(de)
+	})
+
+	pending := map[string]*DataEvent[V]{}
+	var timer <-chan time.Time
+	for {
+		select {
+		case ev := <-evs:
+			if len(pending) == 0 {
+				timer = time.After(debounce)
+			}
+			pending[ev.Namespace+"/"+ev.Name] = ev
+		case <-timer:
+			res := make([]*DataEvent[V], 0, len(pending))
+			for _, ev := range pending {
+				res = append(res, ev)
+			}
+			sort.Slice(res, func(i, j int) bool {
+				if res[i].Namespace != res[j].Namespace {
+					return res[i].Namespace < res[j].Namespace
+				}
+				return res[i].Name < res[j].Name
+			})
+			pending = map[string]*DataEvent[V]{}
+			timer = nil
+			fn(res)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
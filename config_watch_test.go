@@ -0,0 +1,77 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWatchConfigMaps(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	next := make(chan []interface{})
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("fieldSelector") != "metadata.name=a":
+			w.WriteHeader(400)
+		case q.Get("sendInitialEvents") == "true":
+			w.WriteHeader(400)
+		case q.Get("watch") != "true":
+			json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"}})
+		default:
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case evs := <-next:
+					for _, ev := range evs {
+						json.NewEncoder(w).Encode(ev)
+					}
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+	})
+
+	cm := func(rv int, v string) v1.ConfigMap {
+		c := testCM("a", rv)
+		c.Data["v"] = v
+		return c
+	}
+	events := [][]*DataEvent[string]{}
+	kc.WatchConfigMaps(ctx, "default", ConfigWatchOptions{Name: "a", Debounce: 50 * time.Millisecond},
+		func(evs []*DataEvent[string]) {
+			events = append(events, evs)
+			switch len(events) {
+			case 1:
+				next <- []interface{}{
+					map[string]interface{}{"type": "ADDED", "object": cm(11, "1")},
+					map[string]interface{}{"type": "MODIFIED", "object": cm(12, "2")}}
+			case 2:
+				next <- []interface{}{map[string]interface{}{"type": "DELETED", "object": cm(13, "2")}}
+			case 3:
+				cf()
+			}
+		})
+
+	if len(events) != 3 || len(events[0]) != 1 || len(events[1]) != 1 || len(events[2]) != 1 {
+		t.Fatal("Unexpected events", events)
+	}
+	if e := events[0][0]; e.Name != "a" || e.Exists || e.Deleted {
+		t.Error("Expected missing object", e)
+	}
+	if e := events[1][0]; !e.Exists || e.Data["v"] != "2" || e.ResourceVersion != "12" {
+		t.Error("Expected merged changes", e)
+	}
+	if e := events[2][0]; e.Exists || !e.Deleted {
+		t.Error("Expected deleted object", e)
+	}
+}
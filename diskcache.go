@@ -0,0 +1,299 @@
+package mk8s
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DiskCache saves the raw list and watch events in local files, for fast startup
+// and recovery.
+//
+// Each cluster has a directory named after the cluster (the key in K8S.ByName), with
+// one file per watched resource holding the events as JSON lines, and the SyncStatus.
+//
+// At startup the file is replayed to the handler, which can answer queries right away.
+// The watch then resumes from the saved revision - only the changes since the last run
+// are fetched from the server.
+type DiskCache struct {
+	Dir string
+}
+
+// Watch replays the cached objects to the handler, then lists and watches the resource until
+// ctx is done or an error is returned.
+//
+// Objects are decoded into the generated types registered in the client-go scheme, or
+// unstructured.Unstructured for other resources. The objects are kept in memory, to provide
+// the old object in OnUpdate.
+func (dc *DiskCache) Watch(ctx context.Context, kc *K8SCluster, gvr schema.GroupVersionResource,
+	ns string, opts metav1.ListOptions, h cache.ResourceEventHandler) error {
+	dir := filepath.Join(dc.Dir, url.PathEscape(kc.Name))
+	sw := &SyncWatcher{Cluster: kc, GVR: gvr, Namespace: ns, ListOptions: opts,
+		Store: &FileSyncStore{Dir: dir}}
+
+	cw := &cachedWatch{
+		h:       h,
+		gvk:     kindFor(gvr),
+		file:    filepath.Join(dir, url.PathEscape(sw.Key())+".jsonl"),
+		objects: map[string]runtime.Object{},
+	}
+
+	st, err := sw.Store.Load(sw.Key())
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		err = cw.replay()
+		if err != nil {
+			// Corrupted cache - start from scratch
+			logger.Warn("DiskCacheReplay", "file", cw.file, "err", err)
+			cw.objects = map[string]runtime.Object{}
+			st.ResourceVersion = ""
+			st.Continue = ""
+		}
+		cw.synced = st.ResourceVersion != "" && st.Continue == ""
+		sw.Status = st
+	}
+
+	err = cw.compact()
+	if err != nil {
+		return err
+	}
+	defer cw.out.Close()
+
+	sw.Handler = cw.handle
+	sw.OnRelist = cw.onRelist
+	sw.OnListDone = cw.onListDone
+
+	return sw.Run(ctx)
+}
+
+// The file is compacted when the appended events reach diskCacheCompactRatio times the
+// number of objects - with a minimum of diskCacheCompactMin events.
+const (
+	diskCacheCompactRatio = 4
+	diskCacheCompactMin   = 100
+)
+
+type cachedWatch struct {
+	h    cache.ResourceEventHandler
+	gvk  schema.GroupVersionKind
+	file string
+	out  *os.File
+
+	// appended is the number of events written since the last compaction.
+	appended int
+
+	objects map[string]runtime.Object
+
+	// synced is set after the first list or if the cache was replayed
+	synced bool
+
+	// stale is set during a re-list, to find the objects deleted while not watching.
+	stale map[string]bool
+}
+
+// replay reads the events from the file and calls OnAdd for each object.
+func (cw *cachedWatch) replay() error {
+	f, err := os.Open(cw.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		ev := &RawEvent{}
+		err = json.Unmarshal(s.Bytes(), ev)
+		if err != nil {
+			return err
+		}
+		key, obj, err := cw.decode(ev)
+		if err != nil {
+			return err
+		}
+		if ev.Type == watch.Deleted {
+			delete(cw.objects, key)
+		} else {
+			cw.objects[key] = obj
+		}
+	}
+	if s.Err() != nil {
+		return s.Err()
+	}
+
+	for _, obj := range cw.objects {
+		cw.h.OnAdd(obj, true)
+	}
+	logger.Info("DiskCacheReplay", "file", cw.file, "objects", len(cw.objects))
+	return nil
+}
+
+// compact replaces the file with the current objects, and opens it for append.
+func (cw *cachedWatch) compact() error {
+	if cw.out != nil {
+		cw.out.Close()
+	}
+	err := os.MkdirAll(filepath.Dir(cw.file), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(cw.file + ".tmp")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, obj := range cw.objects {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		writeEvent(bw, &RawEvent{Type: watch.Added, Object: raw})
+	}
+	err = bw.Flush()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(cw.file+".tmp", cw.file)
+	if err != nil {
+		return err
+	}
+	cw.appended = 0
+	cw.out, err = os.OpenFile(cw.file, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+func writeEvent(w io.Writer, ev *RawEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func (cw *cachedWatch) handle(ev *RawEvent) error {
+	err := writeEvent(cw.out, ev)
+	if err != nil {
+		return err
+	}
+	key, obj, err := cw.decode(ev)
+	if err != nil {
+		return err
+	}
+
+	old := cw.objects[key]
+	switch ev.Type {
+	case watch.Added, watch.Modified:
+		delete(cw.stale, key)
+		cw.objects[key] = obj
+		if old == nil {
+			cw.h.OnAdd(obj, !cw.synced)
+		} else if resourceVersion(old) != resourceVersion(obj) {
+			cw.h.OnUpdate(old, obj)
+		}
+	case watch.Deleted:
+		delete(cw.objects, key)
+		cw.h.OnDelete(obj)
+	}
+
+	cw.appended++
+	if cw.appended >= diskCacheCompactRatio*max(len(cw.objects), diskCacheCompactMin/diskCacheCompactRatio) {
+		return cw.compact()
+	}
+	return nil
+}
+
+func (cw *cachedWatch) onRelist() {
+	cw.stale = map[string]bool{}
+	for k := range cw.objects {
+		cw.stale[k] = true
+	}
+}
+
+func (cw *cachedWatch) onListDone() {
+	cw.synced = true
+	if cw.stale == nil {
+		return
+	}
+	// Objects not found in the re-list were deleted while we were not watching.
+	for k := range cw.stale {
+		cw.h.OnDelete(cache.DeletedFinalStateUnknown{Key: k, Obj: cw.objects[k]})
+		delete(cw.objects, k)
+	}
+	cw.stale = nil
+	err := cw.compact()
+	if err != nil {
+		logger.Warn("DiskCacheCompact", "file", cw.file, "err", err)
+	}
+}
+
+func (cw *cachedWatch) decode(ev *RawEvent) (string, runtime.Object, error) {
+	obj, err := decodeObject(cw.gvk, ev.Object)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	return key, obj, err
+}
+
+func resourceVersion(obj runtime.Object) string {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return m.GetResourceVersion()
+}
+
+// decodeObject decodes the JSON into the generated type if gvk is registered in the
+// client-go scheme, or an Unstructured object. List items don't include the kind - gvk
+// is used as default.
+func decodeObject(gvk schema.GroupVersionKind, raw []byte) (runtime.Object, error) {
+	if gvk.Kind != "" {
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw, &gvk, nil)
+		if err == nil {
+			return obj, nil
+		}
+	}
+	u := &unstructured.Unstructured{}
+	err := json.Unmarshal(raw, &u.Object)
+	if err != nil {
+		return nil, err
+	}
+	if u.GetAPIVersion() == "" {
+		u.SetAPIVersion(gvk.GroupVersion().String())
+	}
+	return u, nil
+}
+
+// kindFor finds the kind for a resource in the client-go scheme, using the
+// same naming convention as the server.
+func kindFor(gvr schema.GroupVersionResource) schema.GroupVersionKind {
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.GroupVersion() != gvr.GroupVersion() || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		if plural.Resource == gvr.Resource {
+			return gvk
+		}
+	}
+	return gvr.GroupVersion().WithKind("")
+}
@@ -0,0 +1,105 @@
+package mk8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDiskCache(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	deleted := false
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("watch") != "true" {
+			json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+				Items: []v1.ConfigMap{testCM("a", 5), testCM("b", 6)}})
+			return
+		}
+		if q.Get("resourceVersion") == "10" {
+			cm := testCM("a", 11)
+			cm.Data["x"] = "y"
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "MODIFIED", "object": cm})
+		} else if deleted {
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "DELETED", "object": testCM("b", 12)})
+		}
+	})
+
+	dc := &DiskCache{Dir: t.TempDir()}
+	run := func(stop func(ev string, obj *v1.ConfigMap) bool) []string {
+		events := []string{}
+		wctx, wcf := context.WithCancel(ctx)
+		defer wcf()
+		record := func(ev string, obj interface{}) {
+			cm := obj.(*v1.ConfigMap)
+			events = append(events, ev+" "+cm.Name+" "+cm.Data["x"])
+			if stop(ev, cm) {
+				wcf()
+			}
+		}
+		dc.Watch(wctx, kc, cmGVR, "default", metav1.ListOptions{}, cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, initial bool) {
+				if initial {
+					record("init", obj)
+				} else {
+					record("add", obj)
+				}
+			},
+			UpdateFunc: func(old, obj interface{}) { record("update", obj) },
+			DeleteFunc: func(obj interface{}) { record("delete", obj) },
+		})
+		return events
+	}
+
+	events := run(func(ev string, cm *v1.ConfigMap) bool { return ev == "update" })
+	if len(events) != 3 || events[2] != "update a y" {
+		t.Fatal("Unexpected events", events)
+	}
+
+	// Restart: replay the cache, resume the watch.
+	deleted = true
+	events = run(func(ev string, cm *v1.ConfigMap) bool { return ev == "delete" })
+	if len(events) != 3 || events[2] != "delete b " {
+		t.Fatal("Unexpected events after restart", events)
+	}
+	if events[0] != "init a y" && events[1] != "init a y" {
+		t.Error("Expected modified object from cache", events)
+	}
+}
+
+func TestDiskCacheCompact(t *testing.T) {
+	cw := &cachedWatch{h: cache.ResourceEventHandlerFuncs{}, gvk: v1.SchemeGroupVersion.WithKind("ConfigMap"),
+		file: filepath.Join(t.TempDir(), "cm.jsonl"), objects: map[string]runtime.Object{}}
+	if err := cw.compact(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cw.out.Close() }()
+
+	for i := 0; i < 1000; i++ {
+		raw, _ := json.Marshal(testCM(fmt.Sprintf("cm%d", i%10), i))
+		if err := cw.handle(&RawEvent{Type: watch.Modified, Object: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(cw.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n > diskCacheCompactMin+10 || len(cw.objects) != 10 {
+		t.Error("File not compacted", n, len(cw.objects))
+	}
+}
@@ -29,70 +29,45 @@ func (gke *GKE) Autodetect(ctx context.Context, findClusterN string) error {
 
 	// TODO: connect to cluster, find istiod - and keep trying until a working one is found ( fallback )
 
-	if gke.K8S.Default == nil {
+	if gke.K8S.DefaultCluster() == nil {
 		myRegion, _ := RegionFromMetadata()
+		if gke.K8S.Region == "" {
+			gke.K8S.Region = myRegion
+		}
+		gke.K8S.CheckHealth(ctx)
 		cl := gke.FindCluster(myRegion, findClusterN)
 
 		if cl != nil {
 			log.Println("Found default cluster", cl.Name)
 		}
 
-		gke.K8S.Default = cl
+		gke.K8S.SetDefault(cl)
 	}
 	return nil
 }
 
-// FindCluster will iterate all loaded clusters and find a 'default'.
+// FindCluster will find a 'default' among the loaded clusters.
 // This happens on Cloudrun or VMs without a kubeconfig or explicit
 // cluster configured.
 //
 // - will attempt to find a cluster in the same region
-// - if nothing - pick any cluster.
+// - if nothing - pick the first cluster by name.
+//
+// If clusterName is set, clusters with names containing it are preferred.
+// Clusters that failed the last health check are skipped.
 func (kr *GKE) FindCluster(myRegion, clusterName string) *k8s.K8SCluster {
-	var cl *k8s.K8SCluster
-
-	// TODO: probe the clusters, remove bad ones
-
+	cl, _ := kr.K8S.Select("", k8s.SelectOptions{Region: myRegion, ExcludeUnhealthy: true})
+	if len(cl) == 0 {
+		return nil
+	}
 	if clusterName != "" {
-		for _, c := range kr.K8S.ByName {
-			if myRegion != "" && !strings.HasPrefix(c.Location(), myRegion) {
-				continue
-			}
+		for _, c := range cl {
 			if strings.Contains(c.Name, clusterName) {
-				cl = c
 				log.Println("Found cluster with region and name ", myRegion, clusterName, c.Name)
-				break
+				return c
 			}
 		}
-
-		if cl == nil {
-			for _, c := range kr.K8S.ByName {
-				if strings.Contains(c.Name, clusterName) {
-					cl = c
-					log.Println("Found cluster with name ", clusterName, c.Name)
-					break
-				}
-			}
-		}
-	}
-
-	// First attempt to find a cluster in same region, with the name prefix istio (TODO: label or other way to identify
-	// preferred config clusters)
-	if cl == nil {
-		for _, c := range kr.K8S.ByName {
-			if myRegion != "" && !strings.HasPrefix(c.Location(), myRegion) {
-				continue
-			}
-			log.Println("Found cluster with region ", myRegion, c.Name)
-			cl = c
-			break
-		}
-	}
-	if cl == nil {
-		for _, c := range kr.K8S.ByName {
-			cl = c
-			break
-		}
 	}
-	return cl
+	log.Println("Found cluster with region ", myRegion, cl[0].Name)
+	return cl[0]
 }
@@ -25,6 +25,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
@@ -61,23 +62,30 @@ import (
 type GKE struct {
 
 	// Discovered certificates and local identity, metadata.
-	Mesh *meshauth.Mesh `json:-`
+	Mesh *meshauth.Mesh `json:"-"`
 
 	// Clusters loaded from kube config, env, etc.
 	// Default should be set after New().
-	K8S *k8s.K8S `json:-`
+	K8S *k8s.K8S `json:"-"`
 
 	// Returns access tokens for a user or service account (via MDS or  default credentials) or federated access tokens (GKE without a paired GSA).
-	AccessTokenSource oauth2.TokenSource `json:-`
+	AccessTokenSource oauth2.TokenSource `json:"-"`
 
 	// Raw token source - can be the default K8S cluster.
-	TokenSource meshauth.TokenSource `json:-`
+	TokenSource meshauth.TokenSource `json:"-"`
 
 	// Cached project info from CRM- loaded on demand if MDS or env or config are missing
 	// to find project number. Still requires ProjectId
-	projectData *crm.Project `json:-`
+	projectData *crm.Project `json:"-"`
 
-	authScheme string `json:-`
+	authScheme string `json:"-"`
+
+	// HubRateLimiter is shared by all clusters using the Connect Gateway - the quota is
+	// per project. Created by LoadHubClusters if not set.
+	HubRateLimiter *k8s.AdaptiveRateLimiter `json:"-"`
+
+	// hubMu guards the creation of HubRateLimiter.
+	hubMu sync.Mutex
 
 
 	Location string
@@ -131,7 +139,7 @@ func NewModule(module *meshauth.Module) error {
 	startupSpan := trace.SpanFromContext(ctx)
 	if startupSpan.IsRecording() {
 		// Add info about the context to the start span
-		startupSpan.SetAttributes(attribute.Int("k8s_count", len(k.ByName)))
+		startupSpan.SetAttributes(attribute.Int("k8s_count", len(k.Clusters())))
 		if k.Default != nil {
 			startupSpan.SetAttributes(attribute.String("k8s_ctx", k.Default.Name))
 		}
@@ -512,7 +520,18 @@ func (gke *GKE) GKECluster(ctx context.Context, p string) (*k8s.K8SCluster, erro
 // roles/gkehub.gatewayReader for read
 // roles/gkehub.gatewayEditor for write
 func (gke *GKE) LoadHubClusters(ctx context.Context, configProjectId string) ([]*k8s.K8SCluster, error) {
+	cl, err := gke.listHubClusters(ctx, configProjectId)
+	if err != nil {
+		return nil, err
+	}
+	for _, gk := range cl {
+		gke.K8S.AddCluster(gk, true)
+	}
+	return cl, nil
+}
 
+// listHubClusters returns the hub memberships as clusters, without adding them to K8S.
+func (gke *GKE) listHubClusters(ctx context.Context, configProjectId string) ([]*k8s.K8SCluster, error) {
 	opts := gke.options(configProjectId)
 	mc, err := gkehub.NewGkeHubMembershipClient(ctx, opts...)
 	if err != nil {
@@ -587,22 +606,48 @@ func (gke *GKE) LoadHubClusters(ctx context.Context, configProjectId string) ([]
 			Name: ctxName,
 			// Connecting via HUB
 			RestConfig: gke.hubConfig(curl, ctxName),
+			Labels:     hubLabels(nxt, gke.ProjectId(), mn),
 		}
 
 		cl = append(cl, gk)
-
-		gke.K8S.ByName[gk.Name] = gk
 	}
 
 	return cl, nil
 }
 
+// hubLabels returns the membership labels, with the project, name and the location of the
+// cluster if known.
+func hubLabels(m *gkehubpb.Membership, projectId, name string) map[string]string {
+	l := map[string]string{}
+	for k, v := range m.Labels {
+		l[k] = v
+	}
+	l[k8s.LabelProject] = projectId
+	l[k8s.LabelName] = name
+	// //container.googleapis.com/projects/PROJECT/locations/LOCATION/clusters/NAME
+	rl := strings.Split(m.GetEndpoint().GetGkeCluster().GetResourceLink(), "/")
+	if len(rl) == 9 && rl[5] == "locations" {
+		l[k8s.LabelLocation] = rl[6]
+	} else if loc := m.GetMonitoringConfig().GetLocation(); loc != "" {
+		l[k8s.LabelLocation] = loc
+	}
+	return l
+}
+
 func (gke *GKE) hubConfig(url string, ctxName string) *rest.Config {
+	gke.hubMu.Lock()
+	if gke.HubRateLimiter == nil {
+		gke.HubRateLimiter = k8s.NewAdaptiveRateLimiter(k8s.RateLimitOptions{QPS: 40, MaxQPS: 40, Burst: 40})
+	}
+	hrl := gke.HubRateLimiter
+	gke.hubMu.Unlock()
 	return &rest.Config{
 		Host: "https://connectgateway.googleapis.com" + url,
 		AuthProvider: &kubeconfig.AuthProviderConfig{
 			Name: gke.authScheme,
 		},
+		// Adapts to the cluster APF and the gateway quota.
+		RateLimiter: k8s.NewAdaptiveRateLimiter(k8s.RateLimitOptions{Parent: hrl}),
 	}
 }
 
@@ -627,7 +672,18 @@ func (gke *GKE) options(configProjectId string) []option.ClientOption {
 // Requires container.clusters.list
 // This will use the emulated token source.
 func (gke *GKE) LoadGKEClusters(ctx context.Context, configProjectId string, location string) ([]*k8s.K8SCluster, error) {
+	cl, err := gke.listGKEClusters(ctx, configProjectId, location)
+	if err != nil {
+		return nil, err
+	}
+	for _, gkk := range cl {
+		gke.K8S.AddCluster(gkk, false)
+	}
+	return cl, nil
+}
 
+// listGKEClusters returns the GKE clusters, without adding them to K8S.
+func (gke *GKE) listGKEClusters(ctx context.Context, configProjectId string, location string) ([]*k8s.K8SCluster, error) {
 	opts := gke.options(configProjectId)
 
 	if configProjectId == "" {
@@ -663,17 +719,26 @@ func (gke *GKE) LoadGKEClusters(ctx context.Context, configProjectId string, loc
 			// Namespace and KSA are set from the defaults.
 			Namespace: gke.Mesh.MeshCfg.Namespace,
 			// KSA: gke.MeshCfg.Name,
+			Labels: gkeLabels(c, configProjectId),
 		}
 		gkk.RawConfig = c
 		clustersL = append(clustersL, gkk)
-
-		if gke.K8S.ByName[gkk.Name] == nil {
-			gke.K8S.ByName[gkk.Name] = gkk
-		}
 	}
 	return clustersL, nil
 }
 
+// gkeLabels returns the resource labels of a GKE cluster, with the project, location and name.
+func gkeLabels(c *containerpb.Cluster, projectId string) map[string]string {
+	l := map[string]string{}
+	for k, v := range c.ResourceLabels {
+		l[k] = v
+	}
+	l[k8s.LabelProject] = projectId
+	l[k8s.LabelLocation] = c.Location
+	l[k8s.LabelName] = c.Name
+	return l
+}
+
 func (gke *GKE) loadRestsConfig(c *containerpb.Cluster) *rest.Config {
 	caCert, err := base64.StdEncoding.DecodeString(c.MasterAuth.ClusterCaCertificate)
 	if err != nil {
@@ -5,9 +5,11 @@ import (
 	"net/http"
 	"time"
 
+	k8s "github.com/costinm/mk8s"
 	"golang.org/x/oauth2"
 
 	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/transport"
 )
 
@@ -19,16 +21,41 @@ import (
 // Register an oauth2 token source. This takes a dep on the oauth2 library, but
 // client already depends on it.
 // Alternative: set WrapTransport directly on the rest.Config.
+//
+// Exported kubeconfigs use the gke-gcloud-auth-plugin exec plugin for the clusters.
 func RegisterK8STokenProvider(name string, creds oauth2.TokenSource) {
 	rest.RegisterAuthProviderPlugin(name, func(clusterAddress string, config map[string]string, persister rest.AuthProviderConfigPersister) (rest.AuthProvider, error) {
 		return &mdsAuth{creds: creds}, nil
 	})
+	k8s.RegisterAuthExporter(name, func(ctx context.Context, c *k8s.K8SCluster) (*clientcmdapi.AuthInfo, error) {
+		ai := clientcmdapi.NewAuthInfo()
+		ai.Exec = &clientcmdapi.ExecConfig{
+			APIVersion:         "client.authentication.k8s.io/v1beta1",
+			Command:            "gke-gcloud-auth-plugin",
+			InstallHint:        "gcloud components install gke-gcloud-auth-plugin",
+			ProvideClusterInfo: true,
+			InteractiveMode:    clientcmdapi.IfAvailableExecInteractiveMode,
+		}
+		return ai, nil
+	})
 }
 
+// RegisterTokenSource registers a K8S auth provider using the token source.
+//
+// Exported kubeconfigs include a token from the source - it will expire.
 func RegisterTokenSource(name string, creds TokenSource) {
 	rest.RegisterAuthProviderPlugin(name, func(clusterAddress string, config map[string]string, persister rest.AuthProviderConfigPersister) (rest.AuthProvider, error) {
 		return &mdsAuth{tokenSource: creds}, nil
 	})
+	k8s.RegisterAuthExporter(name, func(ctx context.Context, c *k8s.K8SCluster) (*clientcmdapi.AuthInfo, error) {
+		t, err := creds.GetToken(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		ai := clientcmdapi.NewAuthInfo()
+		ai.Token = t
+		return ai, nil
+	})
 }
 
 // TokenSource is a common interface for anything returning Bearer or other kind of tokens.
@@ -0,0 +1,86 @@
+package gcp
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	k8s "github.com/costinm/mk8s"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTelK8S implements k8s.Telemetry using the global OpenTelemetry tracer and meter
+// providers - the exporters (GCP or OTLP) are configured by the app.
+//
+// Each API request gets a client span, and is recorded in the k8s.client.requests counter
+// and k8s.client.duration histogram, with cluster, verb, resource and code attributes.
+type OTelK8S struct {
+	tracer trace.Tracer
+
+	requests    metric.Int64Counter
+	duration    metric.Float64Histogram
+	rateLimiter metric.Float64Histogram
+}
+
+// NewOTelK8S creates the instruments. Use with K8S.SetTelemetry.
+func NewOTelK8S() (*OTelK8S, error) {
+	m := otel.Meter("github.com/costinm/mk8s")
+	t := &OTelK8S{tracer: otel.Tracer("github.com/costinm/mk8s")}
+
+	var err error
+	t.requests, err = m.Int64Counter("k8s.client.requests",
+		metric.WithDescription("K8S API requests"))
+	if err != nil {
+		return nil, err
+	}
+	t.duration, err = m.Float64Histogram("k8s.client.duration", metric.WithUnit("s"),
+		metric.WithDescription("K8S API request latency"))
+	if err != nil {
+		return nil, err
+	}
+	t.rateLimiter, err = m.Float64Histogram("k8s.client.rate_limiter.duration", metric.WithUnit("s"),
+		metric.WithDescription("Time waiting for the client side rate limiter"))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *OTelK8S) StartRequest(ctx context.Context, ri *k8s.RequestInfo) (context.Context, func(*k8s.RequestInfo)) {
+	ctx, span := t.tracer.Start(ctx, "k8s."+ri.Verb, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("k8s.cluster", ri.Cluster),
+			attribute.String("k8s.verb", ri.Verb),
+			attribute.String("k8s.group", ri.Group),
+			attribute.String("k8s.resource", ri.Resource),
+			attribute.String("k8s.namespace", ri.Namespace)))
+
+	return ctx, func(ri *k8s.RequestInfo) {
+		span.SetAttributes(attribute.Int("http.response.status_code", ri.Code))
+		if ri.Err != nil {
+			span.RecordError(ri.Err)
+			span.SetStatus(codes.Error, ri.Err.Error())
+		} else if ri.Code >= 500 {
+			span.SetStatus(codes.Error, strconv.Itoa(ri.Code))
+		}
+		span.End()
+
+		// Namespace is not used for metrics - high cardinality.
+		attrs := metric.WithAttributes(
+			attribute.String("k8s.cluster", ri.Cluster),
+			attribute.String("k8s.verb", ri.Verb),
+			attribute.String("k8s.group", ri.Group),
+			attribute.String("k8s.resource", ri.Resource),
+			attribute.Int("code", ri.Code))
+		t.requests.Add(ctx, 1, attrs)
+		t.duration.Record(ctx, ri.Duration.Seconds(), attrs)
+	}
+}
+
+func (t *OTelK8S) RateLimiterWait(ctx context.Context, cluster string, d time.Duration) {
+	t.rateLimiter.Record(ctx, d.Seconds(), metric.WithAttributes(attribute.String("k8s.cluster", cluster)))
+}
@@ -0,0 +1,90 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	k8s "github.com/costinm/mk8s"
+)
+
+// GKEProvider is a k8s.ClusterProvider listing the GKE clusters in a project.
+type GKEProvider struct {
+	GKE *GKE
+
+	// ProjectId defaults to the project of the GKE module.
+	ProjectId string
+
+	// Location defaults to all locations.
+	Location string
+
+	clusterCache
+}
+
+func (p *GKEProvider) Name() string {
+	return "gke:" + p.ProjectId + "/" + p.Location
+}
+
+func (p *GKEProvider) Discover(ctx context.Context) ([]*k8s.K8SCluster, error) {
+	cl, err := p.GKE.listGKEClusters(ctx, p.ProjectId, p.Location)
+	if err != nil {
+		return nil, err
+	}
+	return p.reuse(cl), nil
+}
+
+// Watch is not supported - the provider is refreshed by K8S.WatchProviders.
+func (p *GKEProvider) Watch(ctx context.Context, fn func([]*k8s.K8SCluster)) error {
+	return k8s.ErrWatchNotSupported
+}
+
+// HubProvider is a k8s.ClusterProvider listing the hub memberships of a project, using
+// the connect gateway.
+type HubProvider struct {
+	GKE *GKE
+
+	// ProjectId defaults to the project of the GKE module.
+	ProjectId string
+
+	clusterCache
+}
+
+func (p *HubProvider) Name() string {
+	return "hub:" + p.ProjectId
+}
+
+func (p *HubProvider) Discover(ctx context.Context) ([]*k8s.K8SCluster, error) {
+	cl, err := p.GKE.listHubClusters(ctx, p.ProjectId)
+	if err != nil {
+		return nil, err
+	}
+	return p.reuse(cl), nil
+}
+
+// Watch is not supported - the provider is refreshed by K8S.WatchProviders.
+func (p *HubProvider) Watch(ctx context.Context, fn func([]*k8s.K8SCluster)) error {
+	return k8s.ErrWatchNotSupported
+}
+
+// clusterCache keeps the clusters returned by the previous Discover, so unchanged clusters
+// keep their clients and rate limiters.
+type clusterCache struct {
+	mu       sync.Mutex
+	clusters map[string]*k8s.K8SCluster
+}
+
+// reuse replaces the clusters with the same name, endpoint and CA with the cached ones.
+func (cc *clusterCache) reuse(cl []*k8s.K8SCluster) []*k8s.K8SCluster {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cur := map[string]*k8s.K8SCluster{}
+	for i, c := range cl {
+		if old := cc.clusters[c.Name]; old != nil && old.RestConfig.Host == c.RestConfig.Host &&
+			bytes.Equal(old.RestConfig.CAData, c.RestConfig.CAData) {
+			cl[i] = old
+		}
+		cur[c.Name] = cl[i]
+	}
+	cc.clusters = cur
+	return cl
+}
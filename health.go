@@ -0,0 +1,149 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// ClusterHealth is the result of the last health check for a cluster.
+type ClusterHealth struct {
+	Healthy bool
+
+	// Latency of the /readyz request.
+	Latency time.Duration
+
+	LastCheck time.Time
+
+	// Err is the error from the last check, if not healthy.
+	Err error
+
+	// Failures is the number of consecutive failed checks.
+	Failures int
+
+	// ServerVersion is the git version reported by the server.
+	ServerVersion string
+}
+
+// Health returns the result of the last check, or nil if the cluster was not checked.
+func (kc *K8SCluster) Health() *ClusterHealth {
+	return kc.health.Load()
+}
+
+// CheckHealth probes /readyz and the discovery /version endpoint, and records the status
+// and latency. The probe times out after 5 seconds.
+func (kc *K8SCluster) CheckHealth(ctx context.Context) *ClusterHealth {
+	ctx, cf := context.WithTimeout(ctx, 5*time.Second)
+	defer cf()
+
+	h := &ClusterHealth{LastCheck: time.Now()}
+	rc, err := kc.RestClient("", "v1")
+	if err == nil {
+		_, err = rc.Get().AbsPath("/readyz").DoRaw(ctx)
+		h.Latency = time.Since(h.LastCheck)
+	}
+	if err == nil {
+		var res []byte
+		res, err = rc.Get().AbsPath("/version").DoRaw(ctx)
+		if err == nil {
+			vi := &version.Info{}
+			err = json.Unmarshal(res, vi)
+			h.ServerVersion = vi.GitVersion
+		}
+	}
+
+	if err != nil {
+		h.Err = err
+		// Concurrent checks each count as a failure.
+		for {
+			prev := kc.health.Load()
+			h.Failures = 1
+			if prev != nil {
+				h.Failures = prev.Failures + 1
+			}
+			if kc.health.CompareAndSwap(prev, h) {
+				break
+			}
+		}
+		logger.Info("ClusterUnhealthy", "cluster", kc.Name, "failures", h.Failures, "err", err)
+		return h
+	}
+	h.Healthy = true
+	kc.health.Store(h)
+	return h
+}
+
+// CheckHealth probes all clusters in parallel. If the Default cluster failed FailoverThreshold
+// consecutive checks, the best healthy cluster becomes the Default and subscribers get
+// a ClusterDefaultChanged event.
+//
+// The best cluster is in the preferred Region if possible, with ties broken by name.
+func (kr *K8S) CheckHealth(ctx context.Context) {
+	clusters := kr.Clusters()
+	if def := kr.DefaultCluster(); def != nil && kr.Cluster(def.Name) != def {
+		clusters = append(clusters, def)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, c := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.CheckHealth(ctx)
+		}()
+	}
+	wg.Wait()
+
+	kr.failover()
+}
+
+// RunHealthCheck calls CheckHealth every interval, until ctx is done.
+func (kr *K8S) RunHealthCheck(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		kr.CheckHealth(ctx)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (kr *K8S) failover() {
+	threshold := kr.FailoverThreshold
+	if threshold == 0 {
+		threshold = 2
+	}
+	def := kr.DefaultCluster()
+	from := ""
+	if def != nil {
+		from = def.Name
+		if h := def.Health(); h == nil || h.Failures < threshold {
+			return
+		}
+	}
+
+	// Only clusters that passed a check, in the preferred Region if possible.
+	var best *K8SCluster
+	cl, _ := kr.Select("", SelectOptions{ExcludeUnhealthy: true})
+	for _, c := range cl {
+		if c.Health() != nil {
+			best = c
+			break
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	if !kr.setDefault(def, best) {
+		return
+	}
+	logger.Info("DefaultClusterChanged", "from", from, "to", best.Name)
+	kr.notify(ClusterEvent{Type: ClusterDefaultChanged, Cluster: best})
+}
@@ -0,0 +1,87 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestHealthFailover(t *testing.T) {
+	ctx := context.Background()
+
+	healthy := map[string]bool{"gke_p_us-central1_a": true, "gke_p_us-east1_b": true, "gke_p_us-east1_c": true}
+	k := &K8S{ByName: map[string]*K8SCluster{}, Region: "us-east1"}
+	for n := range healthy {
+		kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+			if !healthy[n] {
+				w.WriteHeader(500)
+				return
+			}
+			if r.URL.Path == "/version" {
+				json.NewEncoder(w).Encode(version.Info{GitVersion: "v1.30.0"})
+			}
+		})
+		kc.Name = n
+		k.ByName[n] = kc
+	}
+	k.Default = k.ByName["gke_p_us-central1_a"]
+
+	events := []ClusterEvent{}
+	k.Subscribe(func(ev ClusterEvent) {
+		events = append(events, ev)
+	})
+
+	k.CheckHealth(ctx)
+	h := k.Default.Health()
+	if h == nil || !h.Healthy || h.ServerVersion != "v1.30.0" {
+		t.Fatal("Unexpected health", h)
+	}
+
+	healthy["gke_p_us-central1_a"] = false
+	healthy["gke_p_us-east1_b"] = false
+	k.CheckHealth(ctx)
+	if k.Default.Name != "gke_p_us-central1_a" || k.Default.Health().Failures != 1 {
+		t.Fatal("Unexpected failover", k.Default.Health())
+	}
+
+	k.CheckHealth(ctx)
+	if k.Default.Name != "gke_p_us-east1_c" {
+		t.Fatal("Expected failover to the healthy cluster in region", k.Default.Name)
+	}
+	if len(events) != 1 || events[0].Type != ClusterDefaultChanged || events[0].Cluster != k.Default {
+		t.Error("Unexpected events", events)
+	}
+
+	// Removing the Default picks the preferred healthy cluster.
+	healthy["gke_p_us-east1_b"] = true
+	k.CheckHealth(ctx)
+	k.RemoveCluster("gke_p_us-east1_c")
+	if def := k.DefaultCluster(); def == nil || def.Name != "gke_p_us-east1_b" {
+		t.Fatal("Expected the healthy cluster in region", def)
+	}
+	if len(events) != 3 || events[1].Type != ClusterRemoved || events[2].Type != ClusterDefaultChanged {
+		t.Error("Unexpected events", events)
+	}
+}
+
+func TestHealthConcurrentFailures(t *testing.T) {
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kc.CheckHealth(context.Background())
+		}()
+	}
+	wg.Wait()
+	if h := kc.Health(); h.Failures != 10 {
+		t.Error("Lost failures", h.Failures)
+	}
+}
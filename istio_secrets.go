@@ -0,0 +1,206 @@
+package mk8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Istio multicluster uses 'remote secrets' to load additional clusters - each Secret
+// has the label istio/multiCluster=true and one key per cluster, with a kubeconfig as
+// value. The key is the cluster name. 'istioctl create-remote-secret' generates them.
+
+// MultiClusterSecretLabel is the label selecting the Istio remote secrets.
+const MultiClusterSecretLabel = "istio/multiCluster"
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// WatchIstioSecrets watches the Istio remote secrets in namespace ns of the Default cluster,
+// and adds or removes clusters in ByName as the secrets change. Subscribers are notified of
+// each change.
+//
+// Clusters with the same name as a cluster loaded from another source are ignored. Secrets
+// using exec or auth-provider credentials or referencing local files are rejected, like in
+// Istio - anyone able to create the secret could run commands or read the files of the pod.
+//
+// Blocks until ctx is done - watch errors are retried.
+func (kr *K8S) WatchIstioSecrets(ctx context.Context, ns string) error {
+	def := kr.DefaultCluster()
+	if def == nil {
+		return errors.New("No default cluster")
+	}
+
+	sw := &secretWatcher{k: kr, bySecret: map[string]map[string][]byte{}, seen: map[string]bool{}}
+	retryListWatch(ctx, def, secretsGVR, WatchOptions{Namespace: ns,
+		LabelSelector: MultiClusterSecretLabel + "=true"}, sw.handle)
+	return ctx.Err()
+}
+
+type secretWatcher struct {
+	k *K8S
+
+	// bySecret has the kubeconfig for each cluster loaded from a secret.
+	bySecret map[string]map[string][]byte
+
+	// seen tracks the secrets changed since the last initial events bookmark. After a re-list
+	// all secrets are sent again - the ones not seen were deleted while not watching.
+	seen map[string]bool
+}
+
+func (sw *secretWatcher) handle(ev *RawEvent) error {
+	if ev.IsInitialEventsEnd() {
+		for sk := range sw.bySecret {
+			if !sw.seen[sk] {
+				sw.update(sk, nil)
+			}
+		}
+		sw.seen = map[string]bool{}
+		return nil
+	}
+	if ev.Type == watch.Bookmark {
+		return nil
+	}
+
+	s := &v1.Secret{}
+	err := json.Unmarshal(ev.Object, s)
+	if err != nil {
+		logger.Warn("IstioSecretInvalid", "err", err)
+		return nil
+	}
+	sk := s.Namespace + "/" + s.Name
+	if ev.Type == watch.Deleted {
+		delete(sw.seen, sk)
+		sw.update(sk, nil)
+		return nil
+	}
+
+	sw.seen[sk] = true
+	sw.update(sk, s.Data)
+	return nil
+}
+
+// update applies the new kubeconfigs for a secret - nil if the secret was deleted.
+func (sw *secretWatcher) update(sk string, data map[string][]byte) {
+	old := sw.bySecret[sk]
+	kr := sw.k
+
+	names := []string{}
+	for n := range old {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if _, f := data[n]; !f {
+			kr.removeSecretCluster(n, sk)
+		}
+	}
+
+	cur := map[string][]byte{}
+	names = names[:0]
+	for n := range data {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		kc := data[n]
+		if prev, f := old[n]; f && bytes.Equal(prev, kc) {
+			cur[n] = kc
+			continue
+		}
+		if existing := kr.Cluster(n); existing != nil && existing.Source != sk {
+			logger.Warn("IstioSecretDuplicateCluster", "secret", sk, "cluster", n)
+			continue
+		}
+		c, err := kr.clusterFromSecret(n, kc)
+		if err != nil {
+			logger.Warn("IstioSecretInvalidKubeconfig", "secret", sk, "cluster", n, "err", err)
+			if _, f := old[n]; f {
+				kr.removeSecretCluster(n, sk)
+			}
+			continue
+		}
+		c.Source = sk
+		cur[n] = kc
+
+		logger.Info("IstioSecretCluster", "secret", sk, "cluster", n)
+		kr.AddCluster(c, true)
+	}
+
+	if len(cur) == 0 {
+		delete(sw.bySecret, sk)
+	} else {
+		sw.bySecret[sk] = cur
+	}
+}
+
+// removeSecretCluster removes the cluster if it was loaded from the secret - not a cluster
+// with the same name from another source.
+func (kr *K8S) removeSecretCluster(name, sk string) {
+	c := kr.Cluster(name)
+	if c == nil || c.Source != sk {
+		return
+	}
+	if kr.removeCluster(name, c) != nil {
+		logger.Info("IstioSecretClusterRemoved", "secret", sk, "cluster", name)
+	}
+}
+
+// clusterFromSecret creates a cluster using the current context of the kubeconfig.
+func (kr *K8S) clusterFromSecret(name string, kubeconfig []byte) (*K8SCluster, error) {
+	cfg, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := sanitizeKubeConfig(cfg); err != nil {
+		return nil, err
+	}
+	cc := clientcmd.NewNonInteractiveClientConfig(*cfg, cfg.CurrentContext, nil, nil)
+
+	restConfig, err := cc.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	kr.setRateLimits(restConfig)
+	ns, _, _ := cc.Namespace()
+
+	return &K8SCluster{
+		Name:       name,
+		Namespace:  ns,
+		RestConfig: restConfig,
+		RawConfig:  cc,
+	}, nil
+}
+
+// sanitizeKubeConfig rejects kubeconfigs with exec or auth-provider credentials or file
+// references in any user or cluster - not only the ones used by the current context.
+func sanitizeKubeConfig(cfg *clientcmdapi.Config) error {
+	for n, ai := range cfg.AuthInfos {
+		switch {
+		case ai.Exec != nil:
+			return fmt.Errorf("exec credentials are not allowed in secrets, user %s", n)
+		case ai.AuthProvider != nil:
+			return fmt.Errorf("auth-provider credentials are not allowed in secrets, user %s", n)
+		case ai.TokenFile != "":
+			return fmt.Errorf("tokenFile is not allowed in secrets, user %s", n)
+		case ai.ClientCertificate != "":
+			return fmt.Errorf("client-certificate is not allowed in secrets, user %s", n)
+		case ai.ClientKey != "":
+			return fmt.Errorf("client-key is not allowed in secrets, user %s", n)
+		}
+	}
+	for n, c := range cfg.Clusters {
+		if c.CertificateAuthority != "" {
+			return fmt.Errorf("certificate-authority is not allowed in secrets, cluster %s", n)
+		}
+	}
+	return nil
+}
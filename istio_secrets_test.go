@@ -0,0 +1,148 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: c
+  cluster:
+    server: https://remote.example.com
+contexts:
+- name: ctx
+  context:
+    cluster: c
+    user: u
+    namespace: istio-system
+users:
+- name: u
+  user:
+    token: secret-token
+current-context: ctx
+`
+
+func remoteSecret(rv string, clusters ...string) *v1.Secret {
+	s := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-remote-secret", Namespace: "istio-system",
+			ResourceVersion: rv, Labels: map[string]string{MultiClusterSecretLabel: "true"}},
+		Data: map[string][]byte{}}
+	for _, c := range clusters {
+		s.Data[c] = []byte(testKubeconfig)
+	}
+	return s
+}
+
+func TestWatchIstioSecrets(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		enc := json.NewEncoder(w)
+		switch {
+		case q.Get("labelSelector") != MultiClusterSecretLabel+"=true":
+			w.WriteHeader(400)
+		case q.Get("sendInitialEvents") == "true":
+			w.WriteHeader(400)
+		case q.Get("watch") != "true":
+			enc.Encode(v1.SecretList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+				Items: []v1.Secret{*remoteSecret("5", "r1", "r2")}})
+		case q.Get("resourceVersion") == "10":
+			enc.Encode(map[string]interface{}{"type": "MODIFIED", "object": remoteSecret("11", "r2", "r3")})
+			enc.Encode(map[string]interface{}{"type": "DELETED", "object": remoteSecret("12", "r2", "r3")})
+		default:
+			<-r.Context().Done()
+		}
+	})
+	k := &K8S{ByName: map[string]*K8SCluster{"test": kc}, Default: kc}
+
+	events := []string{}
+	k.Subscribe(func(ev ClusterEvent) {
+		events = append(events, string(ev.Type)+" "+ev.Cluster.Name)
+		if ev.Type == ClusterAdded && ev.Cluster.RestConfig.BearerToken != "secret-token" {
+			t.Error("Invalid config", ev.Cluster.RestConfig)
+		}
+		if len(events) == 6 {
+			cf()
+		}
+	})
+	k.WatchIstioSecrets(ctx, "istio-system")
+
+	exp := []string{"ADDED r1", "ADDED r2", "REMOVED r1", "ADDED r3", "REMOVED r2", "REMOVED r3"}
+	for i, e := range exp {
+		if len(events) <= i || events[i] != e {
+			t.Fatal("Unexpected events", events)
+		}
+	}
+	if len(k.ByName) != 1 {
+		t.Error("Expected only the default cluster", k.ByName)
+	}
+}
+
+func TestIstioSecretDuplicate(t *testing.T) {
+	k := &K8S{}
+	sw := &secretWatcher{k: k, bySecret: map[string]map[string][]byte{}, seen: map[string]bool{}}
+	sw.update("istio-system/s1", remoteSecret("1", "r1", "r2").Data)
+	if k.Cluster("r1") == nil || k.Cluster("r2") == nil {
+		t.Fatal("Unexpected clusters", k.Clusters())
+	}
+
+	// Replaced by another source - not removed with the secret.
+	local := &K8SCluster{Name: "r1", Source: "/root/.kube/config"}
+	k.AddCluster(local, true)
+	sw.update("istio-system/s1", nil)
+	if k.Cluster("r1") != local || k.Cluster("r2") != nil {
+		t.Error("Unexpected clusters after delete", k.Clusters())
+	}
+}
+
+func TestClusterFromSecretSanitize(t *testing.T) {
+	k := &K8S{}
+	if _, err := k.clusterFromSecret("ok", []byte(testKubeconfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rejected fields are in a user or cluster not used by the current context.
+	for _, tc := range []struct{ name, user, cluster string }{
+		{name: "tokenFile", user: "tokenFile: /var/run/secrets/token"},
+		{name: "client-certificate", user: "client-certificate: /etc/cert.pem"},
+		{name: "client-key", user: "client-key: /etc/key.pem"},
+		{name: "exec", user: "exec:\n      apiVersion: client.authentication.k8s.io/v1\n      command: sh"},
+		{name: "auth-provider", user: "auth-provider:\n      name: gcp"},
+		{name: "certificate-authority", cluster: "certificate-authority: /etc/ca.pem"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kc := testKubeconfig + `clusters:
+- name: c
+  cluster:
+    server: https://remote.example.com
+- name: other
+  cluster:
+    server: https://other.example.com
+    ` + tc.cluster + `
+users:
+- name: u
+  user:
+    token: secret-token
+- name: other
+  user:
+    ` + tc.user + `
+`
+			_, err := k.clusterFromSecret("bad", []byte(kc))
+			if err == nil || !strings.Contains(err.Error(), tc.name) {
+				t.Error("Expected rejected kubeconfig", err)
+			}
+		})
+	}
+}
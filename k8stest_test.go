@@ -0,0 +1,200 @@
+package mk8s_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/costinm/mk8s"
+	"github.com/costinm/mk8s/pkg/k8stest"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// The list, watch and token tests use the in-memory server from k8stest - only possible in
+// an external test package, k8stest imports mk8s.
+
+var cmGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func testCM(name string) *v1.ConfigMap {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+		Labels: map[string]string{"app": "test"}}, Data: map[string]string{"name": name}}
+}
+
+func TestListRaw(t *testing.T) {
+	ctx := context.Background()
+	s := k8stest.New(t)
+	kc := s.Cluster("test")
+	for _, n := range []string{"a", "b", "c", "d", "e"} {
+		s.MustCreate(cmGVR, testCM(n))
+	}
+
+	names := []string{}
+	lm, err := kc.ListRaw(ctx, cmGVR, "default", metav1.ListOptions{Limit: 2, LabelSelector: "app=test"},
+		func(raw json.RawMessage) error {
+			cm := &v1.ConfigMap{}
+			json.Unmarshal(raw, cm)
+			names = append(names, cm.Name)
+			return nil
+		})
+	if err != nil || len(names) != 5 || names[4] != "e" || lm.ResourceVersion != s.ResourceVersion() {
+		t.Error("Unexpected list", err, names, lm)
+	}
+
+	// A compaction between pages expires the continue token.
+	page := 0
+	_, err = kc.ListRaw(ctx, cmGVR, "default", metav1.ListOptions{Limit: 2}, func(raw json.RawMessage) error {
+		page++
+		if page == 2 {
+			s.Compact()
+		}
+		return nil
+	})
+	if !k8serrors.IsGone(err) {
+		t.Error("Expected expired continue token", err)
+	}
+}
+
+func TestListWatchResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, watchList := range []bool{true, false} {
+		s := k8stest.New(t)
+		s.DisableWatchList = !watchList
+		s.BookmarkInterval = 50 * time.Millisecond
+		kc := s.Cluster("test")
+		s.MustCreate(cmGVR, testCM("a"))
+
+		evs := make(chan *mk8s.RawEvent, 100)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- kc.ListWatch(ctx, cmGVR, "default", metav1.ListOptions{}, func(ev *mk8s.RawEvent) error {
+				evs <- ev
+				return nil
+			})
+		}()
+		next := func(typ watch.EventType) *mk8s.RawEvent {
+			for {
+				select {
+				case ev := <-evs:
+					if ev.Type == watch.Bookmark && typ != watch.Bookmark {
+						continue
+					}
+					if ev.Type != typ {
+						t.Fatal("Unexpected event", watchList, typ, ev.Type, string(ev.Object))
+					}
+					return ev
+				case err := <-errc:
+					t.Fatal("Watch ended", err)
+				case <-time.After(5 * time.Second):
+					t.Fatal("Timeout waiting for", typ)
+				}
+			}
+		}
+
+		next(watch.Added)
+		if ev := next(watch.Bookmark); !ev.IsInitialEventsEnd() {
+			t.Error("Expected initial events end", string(ev.Object))
+		}
+		s.MustCreate(cmGVR, testCM("b"))
+		next(watch.Added)
+
+		// Periodic bookmarks.
+		next(watch.Bookmark)
+
+		// The watch is restarted from the last revision when the server closes it.
+		s.CloseWatches()
+		s.Delete(cmGVR, "default", "b")
+		if ev := next(watch.Deleted); ev.Object == nil {
+			t.Error("Missing deleted object")
+		}
+
+		// The revision is too old after a compaction.
+		s.CloseWatches()
+		s.MustCreate(cmGVR, testCM("c"))
+		s.Compact()
+		select {
+		case err := <-errc:
+			if !k8serrors.IsResourceExpired(err) && !k8serrors.IsGone(err) {
+				t.Error("Expected expired", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the watch to fail")
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	s := k8stest.New(t)
+	k := s.K8S()
+	kc := k.Default
+
+	ts, err := kc.GetTokenWithOptions(ctx, mk8s.TokenOptions{Audiences: []string{"aud1"}, Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, tc, err := k.VerifyToken(ctx, ts.Token, "aud1")
+	if err != nil || c != kc || tc.Namespace != "default" || tc.ServiceAccount != "default" {
+		t.Fatal("Unexpected claims", err, tc)
+	}
+	if _, err := kc.GetTokenWithOptions(ctx, mk8s.TokenOptions{KSA: "missing"}); !mk8s.Is404(err) {
+		t.Error("Expected missing KSA", err)
+	}
+
+	user, err := kc.ReviewToken(ctx, ts.Token, "aud1")
+	if err != nil || user.Username != "system:serviceaccount:default:default" {
+		t.Error("Unexpected review", err, user)
+	}
+	if _, err := kc.ReviewToken(ctx, s.Token("default", "default", time.Hour), "aud2"); !errors.Is(err, mk8s.ErrUnauthenticated) {
+		t.Error("Expected wrong audience", err)
+	}
+
+	s.Authorizer = func(spec *authorizationv1.SubjectAccessReviewSpec) bool {
+		return spec.ResourceAttributes.Verb == "get"
+	}
+	attrs := &authorizationv1.ResourceAttributes{Verb: "delete", Resource: "configmaps", Namespace: "default"}
+	if _, err := kc.Authorize(ctx, ts.Token, attrs, "aud1"); !errors.Is(err, mk8s.ErrDenied) {
+		t.Error("Expected denied", err)
+	}
+	attrs.Verb = "get"
+	if _, err := kc.Authorize(ctx, ts.Token, attrs, "aud1"); err != nil {
+		t.Error(err)
+	}
+
+	// Self reviews use the impersonated user.
+	s.Authorizer = func(spec *authorizationv1.SubjectAccessReviewSpec) bool {
+		return spec.User != "system:serviceaccount:tenant1:app"
+	}
+	if st, err := kc.RunAsImpersonated("tenant1", "app").SelfAccessReview(ctx, attrs); err != nil || st.Allowed {
+		t.Error("Expected denied", err, st)
+	}
+	if st, err := kc.SelfAccessReview(ctx, attrs); err != nil || !st.Allowed {
+		t.Error("Expected allowed", err, st)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	ctx := context.Background()
+	s := k8stest.New(t)
+	k := s.K8S()
+
+	k.CheckHealth(ctx)
+	h := k.DefaultCluster().Health()
+	if h == nil || !h.Healthy || h.ServerVersion != "v1.30.0-k8stest" {
+		t.Fatal("Unexpected health", h)
+	}
+
+	s.InjectFault(k8stest.Fault{Path: "/readyz", Code: 503})
+	k.CheckHealth(ctx)
+	if h := k.DefaultCluster().Health(); h.Healthy || h.Failures != 1 {
+		t.Error("Expected unhealthy", h)
+	}
+}
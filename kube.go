@@ -3,10 +3,10 @@ package mk8s
 import (
 	"context"
 	"errors"
-	"flag"
 	"net"
 	"os"
-	"strings"
+	"slices"
+	"sort"
 	"sync"
 
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
@@ -31,13 +31,225 @@ type K8S struct {
 	// Burst overrides the default 10 burst in the client.
 	Burst int
 
+	// RateLimit, if set, replaces the fixed QPS with an AdaptiveRateLimiter for each cluster
+	// added to the set, starting at QPS and reacting to server throttling.
+	RateLimit *RateLimitOptions
+
 	// Primary config cluster - current context in config, in-cluster
 	// picked by config
+	//
+	// Once the set changes in background (failover, providers), use DefaultCluster.
 	Default *K8SCluster
 
 	// LoadKubeConfig will populate this from a kubeconfig file,
 	// followed optionally by GKE or other sources.
+	//
+	// Once clusters are loaded in background (GKE refresh, remote secrets), use
+	// AddCluster, RemoveCluster, Cluster and Clusters instead of accessing the map.
 	ByName map[string]*K8SCluster
+
+	// clustersMu guards ByName and Default.
+	clustersMu sync.RWMutex
+
+	// Providers discover the clusters, in order. Defaults to the kubeconfig and in-cluster
+	// providers, followed by the providers added with RegisterClusterProvider.
+	Providers []ClusterProvider
+
+	// providerClusters are the clusters returned by each provider, by provider name.
+	providersMu      sync.Mutex
+	providerClusters map[string]map[string]*K8SCluster
+
+	// Region is the preferred location when picking a new Default cluster.
+	Region string
+
+	// Telemetry, if set, records all API requests of the clusters added to the set.
+	// Use SetTelemetry to also instrument the clusters already loaded.
+	Telemetry Telemetry
+
+	// FailoverThreshold is the number of consecutive failed health checks before the
+	// Default cluster is replaced. Defaults to 2.
+	FailoverThreshold int
+
+	// issuers maps the token issuers to the clusters using them, for VerifyToken.
+	issuers sync.Map
+
+	mu sync.Mutex
+
+	// Subscribers for cluster set changes.
+	watchers []*subscriber
+}
+
+// ClusterEventType is the type of change in the cluster set.
+type ClusterEventType string
+
+const (
+	ClusterAdded   ClusterEventType = "ADDED"
+	ClusterRemoved ClusterEventType = "REMOVED"
+	ClusterChanged ClusterEventType = "CHANGED"
+
+	// ClusterDefaultChanged is sent when the Default cluster is replaced - after failing
+	// health checks, being removed, or by SetDefault.
+	ClusterDefaultChanged ClusterEventType = "DEFAULT"
+)
+
+// ClusterEvent is sent to subscribers when a cluster is added, removed, its config
+// changed or it became the Default.
+type ClusterEvent struct {
+	Type ClusterEventType
+
+	// Cluster is the new cluster, or the removed one.
+	Cluster *K8SCluster
+}
+
+// Subscribe registers a function to be called when the set of clusters changes.
+// Clusters loaded before the call are not sent.
+func (kr *K8S) Subscribe(fn func(ClusterEvent)) {
+	kr.subscribe(fn)
+}
+
+type subscriber struct {
+	fn func(ClusterEvent)
+}
+
+// subscribe is like Subscribe, and returns a function removing the subscriber.
+func (kr *K8S) subscribe(fn func(ClusterEvent)) func() {
+	s := &subscriber{fn: fn}
+	kr.mu.Lock()
+	kr.watchers = append(kr.watchers, s)
+	kr.mu.Unlock()
+	return func() {
+		kr.mu.Lock()
+		// Copied - notify may be iterating the old slice.
+		kr.watchers = slices.DeleteFunc(slices.Clone(kr.watchers), func(w *subscriber) bool { return w == s })
+		kr.mu.Unlock()
+	}
+}
+
+func (kr *K8S) notify(ev ClusterEvent) {
+	kr.mu.Lock()
+	w := kr.watchers
+	kr.mu.Unlock()
+	for _, s := range w {
+		s.fn(ev)
+	}
+}
+
+// AddCluster adds a cluster to the set, notifying subscribers with ClusterAdded, or
+// ClusterChanged if a cluster with the same name was replaced.
+//
+// If replace is false and a cluster with the same name exists, nothing is changed.
+// Returns true if the cluster was added.
+func (kr *K8S) AddCluster(c *K8SCluster, replace bool) bool {
+	kr.rateLimit(c)
+	kr.instrument(c)
+	kr.clustersMu.Lock()
+	if kr.ByName == nil {
+		kr.ByName = map[string]*K8SCluster{}
+	}
+	evt := ClusterAdded
+	if old := kr.ByName[c.Name]; old != nil {
+		if !replace || old == c {
+			kr.clustersMu.Unlock()
+			return false
+		}
+		evt = ClusterChanged
+	}
+	kr.ByName[c.Name] = c
+	kr.clustersMu.Unlock()
+
+	kr.notify(ClusterEvent{Type: evt, Cluster: c})
+	return true
+}
+
+// RemoveCluster removes a cluster from the set and notifies subscribers.
+// Returns the removed cluster, or nil if it was not found.
+//
+// If the cluster was the Default, the preferred remaining cluster becomes the Default and
+// subscribers get a ClusterDefaultChanged event.
+func (kr *K8S) RemoveCluster(name string) *K8SCluster {
+	return kr.removeCluster(name, nil)
+}
+
+// removeCluster removes the cluster with the name if it is old, or any cluster if old is nil.
+func (kr *K8S) removeCluster(name string, old *K8SCluster) *K8SCluster {
+	kr.clustersMu.Lock()
+	c := kr.ByName[name]
+	if old != nil && c != old {
+		c = nil
+	}
+	wasDefault := false
+	if c != nil {
+		delete(kr.ByName, name)
+		if kr.Default == c {
+			kr.Default = nil
+			wasDefault = true
+		}
+	}
+	kr.clustersMu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	kr.notify(ClusterEvent{Type: ClusterRemoved, Cluster: c})
+	if wasDefault {
+		if def := kr.SelectOne("", SelectOptions{ExcludeUnhealthy: true}); def != nil && kr.setDefault(nil, def) {
+			logger.Info("DefaultClusterChanged", "from", name, "to", def.Name)
+			kr.notify(ClusterEvent{Type: ClusterDefaultChanged, Cluster: def})
+		}
+	}
+	return c
+}
+
+// DefaultCluster returns the Default cluster, or nil. Safe to call while the Default is
+// changed by failover or by the providers.
+func (kr *K8S) DefaultCluster() *K8SCluster {
+	kr.clustersMu.RLock()
+	defer kr.clustersMu.RUnlock()
+	return kr.Default
+}
+
+// SetDefault replaces the Default cluster, notifying subscribers with ClusterDefaultChanged
+// if it changed.
+func (kr *K8S) SetDefault(c *K8SCluster) {
+	kr.clustersMu.Lock()
+	old := kr.Default
+	kr.Default = c
+	kr.clustersMu.Unlock()
+	if c != nil && c != old {
+		kr.notify(ClusterEvent{Type: ClusterDefaultChanged, Cluster: c})
+	}
+}
+
+// setDefault replaces the Default with c if it is still old. Returns false if the Default
+// was changed concurrently.
+func (kr *K8S) setDefault(old, c *K8SCluster) bool {
+	kr.clustersMu.Lock()
+	defer kr.clustersMu.Unlock()
+	if kr.Default != old {
+		return false
+	}
+	kr.Default = c
+	return true
+}
+
+// Cluster returns the cluster with the given name, or nil.
+func (kr *K8S) Cluster(name string) *K8SCluster {
+	kr.clustersMu.RLock()
+	defer kr.clustersMu.RUnlock()
+	return kr.ByName[name]
+}
+
+// Clusters returns a snapshot of the cluster set, sorted by name.
+func (kr *K8S) Clusters() []*K8SCluster {
+	kr.clustersMu.RLock()
+	res := make([]*K8SCluster, 0, len(kr.ByName))
+	for _, c := range kr.ByName {
+		res = append(res, c)
+	}
+	kr.clustersMu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
 }
 
 var (
@@ -73,8 +285,10 @@ func New(ctx context.Context, ns, name string) (*K8S, error) {
 
 // init will discover a K8S config cluster and return the client.
 //
+// The Providers are run in order - by default:
 // - KUBE_CONFIG takes priority, is checked first
-// - in cluster is probed if KUBE_CONFIG is missing.
+// - in cluster is probed next - the Default if KUBE_CONFIG is missing or has no current context.
+// - providers added with RegisterClusterProvider.
 //
 // Istio Server.initKubeClient handles it for Istio:
 // - FileDir fakes it using files (config controller)
@@ -82,41 +296,33 @@ func New(ctx context.Context, ns, name string) (*K8S, error) {
 // - if no configSources or CLI kubeconfig - use it.
 func (kr *K8S) init(ctx context.Context) error {
 	defer func() {
-		if kr.Default != nil {
-			if kr.Default.Namespace == "" {
-				kr.Default.Namespace = "default"
+		if def := kr.DefaultCluster(); def != nil {
+			if def.Namespace == "" {
+				def.Namespace = "default"
 			}
-			if kr.Default.Name == "" {
-				kr.Default.Name = "default"
+			if def.Name == "" {
+				def.Name = "default"
 			}
 		}
 	}()
-	if kr.Default != nil {
+	if kr.DefaultCluster() != nil {
 		return nil
 	}
 
-	err := kr.LoadKubeConfig("")
-	if err != nil {
-		return err
+	kr.providersMu.Lock()
+	if kr.Providers == nil {
+		kr.Providers = defaultProviders()
 	}
+	kr.providersMu.Unlock()
 
-	err = kr.loadInCluster()
-	if err != nil {
-		return err
+	var errs []error
+	for _, p := range kr.providerList() {
+		if err := kr.refreshProvider(ctx, p); err != nil {
+			logger.Warn("ClusterProviderFailed", "provider", p.Name(), "err", err)
+			errs = append(errs, err)
+		}
 	}
-
-	return nil
-}
-
-// Init klog.InitFlags from an env (to avoid messing with the CLI of
-// the app). For example -v=9 lists full request content, -v=7 lists requests headers
-// TODO: integrate with the dynamic logging.
-func SetK8SLogging(flags string) {
-	// TODO: dynamic
-	fs := &flag.FlagSet{}
-	klog.InitFlags(fs)
-	kf := strings.Split(flags, " ")
-	fs.Parse(kf)
+	return errors.Join(errs...)
 }
 
 // TODO: init using Services/ServiceEntry/Gateway:
@@ -128,20 +334,37 @@ const (
 	rootCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 )
 
-// loadInCluster returns a cluster determined based on in-cluster or MDS config.
-// The extended MDS server is used to cache cluster info to avoid GKE lookups.
-// Equivalent to rest.InClusterConfig.
-func (kr *K8S) loadInCluster() error {
+// InClusterProvider is a ClusterProvider returning the cluster the process is running in,
+// named "incluster". The extended MDS server is used to cache cluster info to avoid
+// GKE lookups. Equivalent to rest.InClusterConfig.
+type InClusterProvider struct {
+	mu      sync.Mutex
+	cluster *K8SCluster
+}
+
+func (p *InClusterProvider) Name() string {
+	return "incluster"
+}
+
+// Discover returns the in-cluster config, or no cluster if the service account token is
+// not mounted.
+func (p *InClusterProvider) Discover(ctx context.Context) ([]*K8SCluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cluster != nil {
+		return []*K8SCluster{p.cluster}, nil
+	}
+
 	token, err := os.ReadFile(tokenFile)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 
 	tlsClientConfig := rest.TLSClientConfig{}
 
 	if _, err := cert.NewPool(rootCAFile); err != nil {
 		klog.Errorf("Expected to load root CA config from %s, but got err: %v", rootCAFile, err)
-		return err
+		return nil, err
 	} else {
 		tlsClientConfig.CAFile = rootCAFile
 	}
@@ -172,12 +395,19 @@ func (kr *K8S) loadInCluster() error {
 		RestConfig: config,
 	}
 
-	kr.ByName[ic.Name] = ic
-	if kr.Default == nil {
-		kr.Default = ic
-	}
+	p.cluster = ic
+	return []*K8SCluster{ic}, nil
+}
+
+// Preferred returns the in-cluster config - the Default if the kubeconfig has no current
+// context.
+func (p *InClusterProvider) Preferred() string {
+	return "incluster"
+}
 
-	return nil
+// Watch is not supported - the token is reloaded from the file by the client.
+func (p *InClusterProvider) Watch(ctx context.Context, fn func([]*K8SCluster)) error {
+	return ErrWatchNotSupported
 }
 
 func Is404(err error) bool {
@@ -192,8 +422,9 @@ func Is404(err error) bool {
 // GetToken returns a token with the given audience for the default KSA, using CreateToken request.
 // Used by the STS token exchanger.
 func (kr *K8S) GetToken(ctx context.Context, aud string) (string, error) {
-	if kr.Default == nil {
+	def := kr.DefaultCluster()
+	if def == nil {
 		return "", errors.New("No default cluster")
 	}
-	return kr.Default.GetTokenRaw(ctx, kr.Default.Namespace, kr.Default.KSA, aud)
+	return def.GetTokenRaw(ctx, def.Namespace, def.KSA, aud)
 }
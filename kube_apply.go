@@ -0,0 +1,170 @@
+package mk8s
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// ApplyOptions customize ApplyConfigMap and ApplySecret.
+type ApplyOptions struct {
+	// FieldManager for server side apply. Defaults to DefaultFieldManager.
+	FieldManager string
+
+	// Force takes ownership of fields owned by other managers, instead of failing with
+	// a conflict.
+	Force bool
+
+	// ResourceVersion, if set, makes the apply fail with a conflict if the object was
+	// changed since this revision.
+	ResourceVersion string
+
+	// Labels to set on the object.
+	Labels map[string]string
+}
+
+// ClusterResult is the result of a write to one of the clusters in a K8S set.
+type ClusterResult[T interface{}] struct {
+	Cluster string
+	Object  *T
+	Err     error
+}
+
+// ApplyConfigMap creates or updates a ConfigMap using server side apply. Only the keys in
+// data are owned by the field manager - keys set by other managers are kept.
+func (kc *K8SCluster) ApplyConfigMap(ctx context.Context, ns, name string, data map[string]string,
+	opts ApplyOptions) (*v1.ConfigMap, error) {
+	c, err := NewK8SClient[v1.ConfigMap](kc, configMapsGVR)
+	if err != nil {
+		return nil, err
+	}
+	c.FieldManager = opts.FieldManager
+	return c.Apply(ctx, ns, name, &v1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+		ObjectMeta: applyMeta(ns, name, opts),
+		Data:       data,
+	}, opts.Force)
+}
+
+// ApplySecret creates or updates a Secret using server side apply, like ApplyConfigMap.
+func (kc *K8SCluster) ApplySecret(ctx context.Context, ns, name string, data map[string][]byte,
+	opts ApplyOptions) (*v1.Secret, error) {
+	c, err := NewK8SClient[v1.Secret](kc, secretsGVR)
+	if err != nil {
+		return nil, err
+	}
+	c.FieldManager = opts.FieldManager
+	return c.Apply(ctx, ns, name, &v1.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: applyMeta(ns, name, opts),
+		Data:       data,
+	}, opts.Force)
+}
+
+// UpdateConfigMap reads the ConfigMap, calls fn with the current data (nil if it doesn't
+// exist) and applies the returned data using the read resourceVersion. If the ConfigMap
+// was changed in the meantime, the update is retried. Conflicts with the fields of other
+// managers are returned without retrying - use Force to take ownership.
+func (kc *K8SCluster) UpdateConfigMap(ctx context.Context, ns, name string,
+	fn func(map[string]string) (map[string]string, error), opts ApplyOptions) (*v1.ConfigMap, error) {
+	var res *v1.ConfigMap
+	err := retry.OnError(retry.DefaultRetry, isVersionConflict, func() error {
+		cur, err := Get[v1.ConfigMap](ctx, kc, configMapsGVR, ns, name)
+		o := opts
+		var data map[string]string
+		if err == nil {
+			data, o.ResourceVersion = cur.Data, cur.ResourceVersion
+		} else if !Is404(err) {
+			return err
+		}
+		data, err = fn(data)
+		if err != nil {
+			return err
+		}
+		res, err = kc.ApplyConfigMap(ctx, ns, name, data, o)
+		return err
+	})
+	return res, err
+}
+
+// UpdateSecret is like UpdateConfigMap, for Secrets.
+func (kc *K8SCluster) UpdateSecret(ctx context.Context, ns, name string,
+	fn func(map[string][]byte) (map[string][]byte, error), opts ApplyOptions) (*v1.Secret, error) {
+	var res *v1.Secret
+	err := retry.OnError(retry.DefaultRetry, isVersionConflict, func() error {
+		cur, err := Get[v1.Secret](ctx, kc, secretsGVR, ns, name)
+		o := opts
+		var data map[string][]byte
+		if err == nil {
+			data, o.ResourceVersion = cur.Data, cur.ResourceVersion
+		} else if !Is404(err) {
+			return err
+		}
+		data, err = fn(data)
+		if err != nil {
+			return err
+		}
+		res, err = kc.ApplySecret(ctx, ns, name, data, o)
+		return err
+	})
+	return res, err
+}
+
+// ApplyConfigMap applies the ConfigMap to all clusters in parallel, returning the result
+// for each cluster sorted by name.
+func (kr *K8S) ApplyConfigMap(ctx context.Context, ns, name string, data map[string]string,
+	opts ApplyOptions) []*ClusterResult[v1.ConfigMap] {
+	return applyAll(kr, func(c *K8SCluster) (*v1.ConfigMap, error) {
+		return c.ApplyConfigMap(ctx, ns, name, data, opts)
+	})
+}
+
+// ApplySecret applies the Secret to all clusters in parallel, like ApplyConfigMap.
+func (kr *K8S) ApplySecret(ctx context.Context, ns, name string, data map[string][]byte,
+	opts ApplyOptions) []*ClusterResult[v1.Secret] {
+	return applyAll(kr, func(c *K8SCluster) (*v1.Secret, error) {
+		return c.ApplySecret(ctx, ns, name, data, opts)
+	})
+}
+
+func applyAll[T interface{}](kr *K8S, fn func(*K8SCluster) (*T, error)) []*ClusterResult[T] {
+	clusters := kr.Clusters()
+	res := make([]*ClusterResult[T], len(clusters))
+	wg := sync.WaitGroup{}
+	for i, c := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obj, err := fn(c)
+			res[i] = &ClusterResult[T]{Cluster: c.Name, Object: obj, Err: err}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// isVersionConflict returns true for conflicts caused by a changed resourceVersion. Server
+// side apply conflicts with other field managers are not retried - the result would be
+// the same.
+func isVersionConflict(err error) bool {
+	if !k8serrors.IsConflict(err) {
+		return false
+	}
+	if st, ok := err.(k8serrors.APIStatus); ok && st.Status().Details != nil {
+		for _, c := range st.Status().Details.Causes {
+			if c.Type == metav1.CauseTypeFieldManagerConflict {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func applyMeta(ns, name string, opts ApplyOptions) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: ns, Name: name, Labels: opts.Labels,
+		ResourceVersion: opts.ResourceVersion}
+}
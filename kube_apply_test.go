@@ -0,0 +1,105 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// applyServer is a minimal server for ConfigMap get and apply, with resourceVersion
+// preconditions. The "owned" key is owned by another field manager.
+func applyServer(t *testing.T, conflicts int) *K8SCluster {
+	mu := sync.Mutex{}
+	cur := testCM("a", 1)
+	cur.Data = map[string]string{"k1": "v1"}
+	return httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(cur)
+			return
+		}
+		if r.URL.Query().Get("fieldManager") != "test" {
+			w.WriteHeader(400)
+			return
+		}
+		cm := &v1.ConfigMap{}
+		json.NewDecoder(r.Body).Decode(cm)
+		if _, f := cm.Data["owned"]; f {
+			w.WriteHeader(409)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: "Failure", Reason: metav1.StatusReasonConflict, Code: 409,
+				Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{
+					Type: metav1.CauseTypeFieldManagerConflict, Field: ".data.owned"}}}})
+			return
+		}
+		if conflicts > 0 || (cm.ResourceVersion != "" && cm.ResourceVersion != cur.ResourceVersion) {
+			if conflicts > 0 {
+				// Someone else changed the object.
+				conflicts--
+				cur.ResourceVersion += "1"
+			}
+			w.WriteHeader(409)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: "Failure", Reason: metav1.StatusReasonConflict, Code: 409})
+			return
+		}
+		for k, v := range cm.Data {
+			cur.Data[k] = v
+		}
+		cur.ResourceVersion += "1"
+		json.NewEncoder(w).Encode(cur)
+	})
+}
+
+func TestApplyConfigMap(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	kc := applyServer(t, 1)
+	opts := ApplyOptions{FieldManager: "test"}
+	res, err := kc.UpdateConfigMap(ctx, "default", "a", func(data map[string]string) (map[string]string, error) {
+		return map[string]string{"k2": data["k1"] + "-2"}, nil
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Data["k1"] != "v1" || res.Data["k2"] != "v1-2" {
+		t.Error("Unexpected result", res.Data)
+	}
+
+	// Conflicts with other field managers are not retried.
+	calls := 0
+	_, err = kc.UpdateConfigMap(ctx, "default", "a", func(data map[string]string) (map[string]string, error) {
+		calls++
+		return map[string]string{"owned": "x"}, nil
+	}, opts)
+	if !k8serrors.IsConflict(err) || calls != 1 {
+		t.Error("Expected field manager conflict", err, calls)
+	}
+
+	opts.ResourceVersion = "1"
+	_, err = kc.ApplyConfigMap(ctx, "default", "a", map[string]string{"k3": "v3"}, opts)
+	if err == nil {
+		t.Error("Expected conflict")
+	}
+
+	k := &K8S{}
+	for _, n := range []string{"c2", "c1"} {
+		c := applyServer(t, 0)
+		c.Name = n
+		k.AddCluster(c, true)
+	}
+	all := k.ApplyConfigMap(ctx, "default", "a", map[string]string{"k3": "v3"}, ApplyOptions{FieldManager: "test"})
+	if len(all) != 2 || all[0].Cluster != "c1" || all[0].Err != nil || all[1].Object.Data["k3"] != "v3" {
+		t.Error("Unexpected results", all)
+	}
+}
@@ -0,0 +1,159 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultFieldManager is used for server side apply if no other manager is set.
+const DefaultFieldManager = "mk8s"
+
+// DefaultListLimit is the page size used by List if opts.Limit is not set - same as the
+// client-go pager.
+const DefaultListLimit = 500
+
+// K8SClient is a typed wrapper for a REST client for a specific resource.
+//
+// T is decoded using encoding/json - it can be a generated type like v1.Pod
+// or any struct matching the JSON of a CRD, like mesh/v1.Ptr. No scheme registration
+// or generated clientset is required.
+type K8SClient[T interface{}] struct {
+	Cluster *K8SCluster
+
+	GVR schema.GroupVersionResource
+
+	// FieldManager is used for Apply. Defaults to DefaultFieldManager.
+	FieldManager string
+
+	rc *rest.RESTClient
+}
+
+// NewK8SClient returns a typed client for a resource in the cluster.
+func NewK8SClient[T interface{}](kc *K8SCluster, gvr schema.GroupVersionResource) (*K8SClient[T], error) {
+	rc, err := kc.RestClient(gvr.Group, gvr.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &K8SClient[T]{Cluster: kc, GVR: gvr, rc: rc}, nil
+}
+
+// Get returns a single object, using a temporary K8SClient.
+func Get[T interface{}](ctx context.Context, kc *K8SCluster, gvr schema.GroupVersionResource, ns, name string) (*T, error) {
+	c, err := NewK8SClient[T](kc, gvr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Get(ctx, ns, name)
+}
+
+// List returns all objects matching the filter, using a temporary K8SClient. The objects
+// are requested in pages of DefaultListLimit.
+func List[T interface{}](ctx context.Context, kc *K8SCluster, gvr schema.GroupVersionResource, ns string, filter func(*T) bool) ([]*T, error) {
+	c, err := NewK8SClient[T](kc, gvr)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := c.List(ctx, ns, metav1.ListOptions{Limit: DefaultListLimit}, filter)
+	return res, err
+}
+
+// Get returns the object with the given name. Use "" for ns if the resource is cluster-scoped.
+func (c *K8SClient[T]) Get(ctx context.Context, ns, name string) (*T, error) {
+	res, err := c.rc.Get().
+		NamespaceIfScoped(ns, ns != "").
+		Resource(c.GVR.Resource).
+		Name(name).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decode[T](res)
+}
+
+// List returns the objects matching opts and the filter, and the resourceVersion
+// of the list which can be used to start a Watch.
+//
+// Pages are requested using opts.Limit - the filter is applied as each page is
+// received, so only matching objects are kept in memory. A nil filter returns all
+// objects.
+func (c *K8SClient[T]) List(ctx context.Context, ns string, opts metav1.ListOptions, filter func(*T) bool) ([]*T, string, error) {
+	res := []*T{}
+	lm, err := listRaw(ctx, c.rc, c.GVR.Resource, ns, opts, func(raw json.RawMessage) error {
+		t, err := decode[T](raw)
+		if err != nil {
+			return err
+		}
+		if filter == nil || filter(t) {
+			res = append(res, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return res, lm.ResourceVersion, nil
+}
+
+// Watch calls fn for each change, until the server closes the watch, ctx is done or fn
+// returns an error. Start from the resourceVersion returned by List to not miss events.
+//
+// For BOOKMARK events, only the resourceVersion in the object metadata is set.
+func (c *K8SClient[T]) Watch(ctx context.Context, ns string, opts metav1.ListOptions, fn func(watch.EventType, *T) error) error {
+	return watchRaw(ctx, c.rc, c.GVR.Resource, ns, opts, func(ev *RawEvent) error {
+		t, err := decode[T](ev.Object)
+		if err != nil {
+			return err
+		}
+		return fn(ev.Type, t)
+	})
+}
+
+// Apply creates or updates the object using server side apply, returning the merged object.
+//
+// obj must have apiVersion and kind set, and only include the fields owned by this
+// client. If force is set, conflicts with other field managers are resolved by taking
+// ownership of the fields.
+func (c *K8SClient[T]) Apply(ctx context.Context, ns, name string, obj *T, force bool) (*T, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fm := c.FieldManager
+	if fm == "" {
+		fm = DefaultFieldManager
+	}
+	req := c.rc.Patch(types.ApplyPatchType).
+		NamespaceIfScoped(ns, ns != "").
+		Resource(c.GVR.Resource).
+		Name(name).
+		Param("fieldManager", fm).
+		Body(body)
+	if force {
+		req.Param("force", "true")
+	}
+	// Not DoRaw - Error decodes the Status, with the field manager conflict causes.
+	r := req.Do(ctx)
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	res, err := r.Raw()
+	if err != nil {
+		return nil, err
+	}
+	return decode[T](res)
+}
+
+func decode[T interface{}](raw []byte) (*T, error) {
+	t := new(T)
+	err := json.Unmarshal(raw, t)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
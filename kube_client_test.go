@@ -0,0 +1,138 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+var cmGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// httpCluster returns a cluster using a test http server.
+func httpCluster(t *testing.T, h http.HandlerFunc) *K8SCluster {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &K8SCluster{Name: "test", Namespace: "default",
+		RestConfig: &rest.Config{Host: srv.URL}}
+}
+
+func testCM(name string, rv int) v1.ConfigMap {
+	return v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+			ResourceVersion: fmt.Sprintf("%d", rv)},
+		Data: map[string]string{"name": name}}
+}
+
+func TestK8SClient(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	var applied []byte
+	var limits []string
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("watch") != "true" && r.URL.Path == "/api/v1/namespaces/default/configmaps" {
+			limits = append(limits, q.Get("limit"))
+		}
+		switch {
+		case r.Method == "PATCH":
+			if r.Header.Get("Content-Type") != "application/apply-patch+yaml" || q.Get("fieldManager") != "test" {
+				w.WriteHeader(400)
+				return
+			}
+			applied, _ = io.ReadAll(r.Body)
+			w.Write(applied)
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps/a":
+			json.NewEncoder(w).Encode(testCM("a", 1))
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps/b":
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: "Failure", Reason: metav1.StatusReasonNotFound, Code: 404})
+		case q.Get("watch") == "true":
+			for i := 3; i < 5; i++ {
+				json.NewEncoder(w).Encode(map[string]interface{}{"type": "ADDED", "object": testCM(fmt.Sprintf("w%d", i), i)})
+			}
+		case q.Get("continue") == "":
+			json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{Continue: "next"},
+				Items: []v1.ConfigMap{testCM("a", 1)}})
+		default:
+			json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "2"},
+				Items: []v1.ConfigMap{testCM("b", 2), testCM("c", 2)}})
+		}
+	})
+
+	c, err := NewK8SClient[v1.ConfigMap](kc, cmGVR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		cm, err := c.Get(ctx, "default", "a")
+		if err != nil || cm.Data["name"] != "a" {
+			t.Fatal(cm, err)
+		}
+		_, err = Get[v1.ConfigMap](ctx, kc, cmGVR, "default", "b")
+		if !Is404(err) {
+			t.Fatal("Expected 404", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		res, rv, err := c.List(ctx, "default", metav1.ListOptions{Limit: 1}, func(cm *v1.ConfigMap) bool {
+			return cm.Name != "b"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rv != "2" || len(res) != 2 || res[1].Name != "c" {
+			t.Error("Unexpected list", rv, res)
+		}
+
+		// The package List is paged too.
+		limits = nil
+		res, err = List[v1.ConfigMap](ctx, kc, cmGVR, "default", func(cm *v1.ConfigMap) bool {
+			return cm.Name != "b"
+		})
+		if err != nil || len(res) != 2 || len(limits) != 2 || limits[0] != "500" {
+			t.Error("Unexpected paged list", err, res, limits)
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		names := []string{}
+		err := c.Watch(ctx, "default", metav1.ListOptions{ResourceVersion: "2"}, func(et watch.EventType, cm *v1.ConfigMap) error {
+			names = append(names, cm.Name)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 2 || names[0] != "w3" {
+			t.Error("Unexpected events", names)
+		}
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		c.FieldManager = "test"
+		cm := testCM("d", 0)
+		res, err := c.Apply(ctx, "default", "d", &cm, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Name != "d" || len(applied) == 0 {
+			t.Error("Unexpected apply result", res)
+		}
+	})
+}
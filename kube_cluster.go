@@ -3,10 +3,12 @@ package mk8s
 import (
 	"context"
 	"fmt"
-	"log/slog"
-	"strings"
+	"sync"
+	"sync/atomic"
 
+	"golang.org/x/sync/singleflight"
 	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
@@ -26,7 +28,7 @@ type K8SCluster struct {
 	//
 	// The URL can be extracted with rest.DefaultServerURLFor(RestConfig)
 	// Http client properly configured to talk with K8SAPIserver directly: rest.HTTPClientFor(RestConfig)
-	RestConfig *rest.Config `json:-`
+	RestConfig *rest.Config `json:"-"`
 
 	// The name should be mangled - gke_PROJECT_LOCATION_NAME or connectgateway_PROJECT_NAME
 	// or hostname.
@@ -46,14 +48,64 @@ type K8SCluster struct {
 	// this KSA. If not set - default SA will be used.
 	KSA string
 
+	// TokenExpirationSeconds is the requested lifetime of tokens returned by GetToken.
+	// If 0, the server default (1h) is used.
+	TokenExpirationSeconds int64 `json:",omitempty"`
+
+	// TokenBoundObjectRef, if set, binds the tokens returned by GetToken to a Pod, Secret
+	// or Node - the token is invalid once the object is deleted.
+	TokenBoundObjectRef *authenticationv1.BoundObjectReference `json:",omitempty"`
+
+	// Labels are set by the discovery source - GKE resource labels, Hub membership labels or
+	// the kubeconfig LabelsExtension. LabelSet adds the labels derived from the name and
+	// the mk8s-cluster-info ConfigMap.
+	Labels map[string]string `json:",omitempty"`
+
+	// Source identifies where the cluster was loaded from - for example the namespace/name
+	// of an Istio remote secret, or the kubeconfig file defining the context.
+	Source string
+
 	// TODO: lazy load. Should be cached.
-	client *kubernetes.Clientset `json:-`
+	client *kubernetes.Clientset `json:"-"`
 
 	// RawConfig can be a GCP res.Config
-	RawConfig interface{} `json:-`
+	RawConfig interface{} `json:"-"`
+
+	// Labels loaded from the mk8s-cluster-info ConfigMap.
+	clusterInfo atomic.Pointer[map[string]string]
+
+	// Set once the RestConfig is wrapped for K8S.Telemetry.
+	instrumented atomic.Bool
+
+	// Set once the RestConfig uses an AdaptiveRateLimiter.
+	rateLimiter atomic.Pointer[AdaptiveRateLimiter]
+
+	// Set if the server rejected a streaming list (WatchList feature gate disabled).
+	watchListUnsupported atomic.Bool
+
+	// Issuer and keys used to verify the cluster tokens.
+	oidc atomic.Pointer[oidcKeys]
+
+	// Last failed discovery, retried with backoff. oidcFetch collapses concurrent fetches.
+	oidcFailed atomic.Pointer[oidcFailure]
+	oidcFetch  singleflight.Group
 
-	// Cached (not sure if needed)
-	project, location, name string `json:-`
+	// Result of the last CheckHealth.
+	health atomic.Pointer[ClusterHealth]
+
+	// Cached TokenReview and SubjectAccessReview results.
+	tokenReviews  ttlCache[*authenticationv1.TokenReviewStatus]
+	accessReviews ttlCache[*authorizationv1.SubjectAccessReviewStatus]
+
+	// Cached tokens, shared with clusters created by RunAs and Impersonate.
+	tokens     *tokenCache
+	tokensOnce sync.Once
+
+	// Clusters created by Impersonate, by namespace, KSA and impersonation config.
+	impersonated sync.Map
+
+	// base is the cluster an impersonating cluster was created from - used to get tokens.
+	base *K8SCluster
 }
 
 func NewK8SCluster(ctx context.Context, ns, name string) *K8SCluster {
@@ -68,60 +120,34 @@ func (kr *K8SCluster) String() string {
 // Return a new K8S cluster with same config and client, but different default
 // namespace and KSA.
 // The GetToken() requests will use the specified K8S namespace and KSA instead of the
-// default.
+// default. RunAsImpersonated also makes the API calls as the KSA.
 func (kr *K8SCluster) RunAs(ns, ksa string) *K8SCluster {
-	return &K8SCluster{RestConfig: kr.RestConfig, client: kr.Client(), Name: kr.Name,
-		Namespace: ns, KSA: ksa}
+	c := &K8SCluster{RestConfig: kr.RestConfig, client: kr.Client(), Name: kr.Name,
+		Namespace: ns, KSA: ksa, tokens: kr.tokenCache(), Labels: kr.Labels,
+		TokenExpirationSeconds: kr.TokenExpirationSeconds, TokenBoundObjectRef: kr.TokenBoundObjectRef}
+	c.clusterInfo.Store(kr.clusterInfo.Load())
+	return c
 }
 
+// Label returns a label of the cluster - see LabelSet.
 func (k *K8SCluster) Label(ctx context.Context, name string) string {
-	switch name {
-	case "location": return k.Location()
-	case "project":
-		k.GcpInfo()
-		return k.project
-	case "name":
-		k.GcpInfo()
-		return k.name
-	}
-	return ""
+	return k.LabelSet()[name]
 }
 
+// Location returns the GKE location (region or zone) of the cluster.
 func (k *K8SCluster) Location() string {
-	if k.location == "" {
-		k.GcpInfo()
-	}
-	return k.location
+	return k.LabelSet()[LabelLocation]
 }
 
+// GcpInfo returns the project, location and short name of the cluster - the name is the
+// cluster name if not set.
 func (k *K8SCluster) GcpInfo() (string, string, string) {
-	if k.location != "" {
-		return k.project, k.location, k.Name
-	}
-	cf := k.Name
-	if strings.HasPrefix(cf, "gke_") {
-		parts := strings.Split(cf, "_")
-		k.project = parts[1]
-		k.location = parts[2]
-		k.name = parts[3]
-		if len(parts) > 3 {
-			// TODO: if env variable with cluster name/location are set - use that for context
-			return parts[1], parts[2], parts[3]
-		}
-	}
-	if strings.HasPrefix(cf, "connectgateway_") {
-		parts := strings.Split(cf, "_")
-		if len(parts) > 2 {
-			// TODO: if env variable with cluster name/location are set - use that for context
-			// TODO: if opinionanted naming scheme is used for cg names (location-name) - extract it.
-			k.project = parts[1]
-
-			// TODO: use registration names that include the location !
-
-			return parts[1], "", parts[2]
-		}
+	l := k.LabelSet()
+	name := l[LabelName]
+	if name == "" {
+		name = k.Name
 	}
-	return "", "", cf
+	return l[LabelProject], l[LabelLocation], name
 }
 
 type K8SRest interface {
@@ -135,7 +161,7 @@ func (kr *K8SCluster) Client() *kubernetes.Clientset {
 		var err error
 		kr.client, err = kubernetes.NewForConfig(kr.RestConfig)
 		if err != nil {
-			slog.Error("Failed to create K8S client", "err", err)
+			logger.Error("Failed to create K8S client", "err", err)
 		}
 	}
 	return kr.client
@@ -178,29 +204,22 @@ func (k *K8SCluster) GetToken(ctx context.Context, aud string) (string, error) {
 	return k.GetTokenRaw(ctx, k.Namespace, k.KSA, aud)
 }
 
+// GetTokenRaw returns a token for the KSA with the given audience, using a TokenRequest.
+//
+// Tokens are cached until close to expiration, and refreshed in background.
 func (k *K8SCluster) GetTokenRaw(ctx context.Context,	ns, ksa, aud string) (string, error) {
-
-	if ns == "" {
-		ns = "default"
-	}
-	if ksa == "" {
-		ksa = "default"
-	}
-
-	treq := &authenticationv1.TokenRequest{
-		Spec: authenticationv1.TokenRequestSpec{
-			Audiences: []string{aud},
-		},
-	}
-	ts, err := k.Client().CoreV1().ServiceAccounts(ns).CreateToken(ctx,
-		ksa, treq, metav1.CreateOptions{})
+	ts, err := k.GetTokenWithOptions(ctx, TokenOptions{Namespace: ns, KSA: ksa,
+		Audiences: []string{aud}, ExpirationSeconds: k.TokenExpirationSeconds,
+		BoundObjectRef: k.TokenBoundObjectRef})
 	if err != nil {
 		return "", err
 	}
 
-	return ts.Status.Token, nil
+	return ts.Token, nil
 }
 
+// GetCM returns the data of a ConfigMap, or an empty map if it doesn't exist.
+// WatchConfigMaps can be used to get the changes.
 func (kr *K8SCluster) GetCM(ctx context.Context, ns string, name string) (map[string]string, error) {
 	s, err := kr.Client().CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
@@ -213,6 +232,8 @@ func (kr *K8SCluster) GetCM(ctx context.Context, ns string, name string) (map[st
 	return s.Data, nil
 }
 
+// GetSecret returns the data of a Secret, or an empty map if it doesn't exist.
+// WatchSecrets can be used to get the changes.
 func (kr *K8SCluster) GetSecret(ctx context.Context, ns string, name string) (map[string][]byte, error) {
 	s, err := kr.Client().CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
@@ -224,18 +245,3 @@ func (kr *K8SCluster) GetSecret(ctx context.Context, ns string, name string) (ma
 
 	return s.Data, nil
 }
-
-// Experiments
-
-func Get[T interface{}]() {
-
-}
-
-func List[T interface{}](func(T) bool) {
-
-}
-
-// K8SClient is a typed wrapper for a REST client for a specific resource.
-type K8SClient[T interface{}] struct {
-
-}
@@ -0,0 +1,74 @@
+package mk8s
+
+import (
+	"encoding/json"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// Impersonate returns a cluster making all API calls as another user, using the K8S
+// impersonation headers - the calls have the permissions of the user instead of the
+// cluster credentials, which need the 'impersonate' RBAC verb for the users, groups,
+// uids and extra.
+//
+// The clusters and their clients are cached - the same config returns the same cluster,
+// until ForgetImpersonated is called.
+// Tokens are requested with the cluster credentials, and shared with the cluster.
+func (kr *K8SCluster) Impersonate(ic rest.ImpersonationConfig) *K8SCluster {
+	return kr.impersonate(kr.Namespace, kr.KSA, ic)
+}
+
+// RunAsImpersonated is like RunAs, but all API calls are also made as the KSA - for
+// controllers acting in a tenant namespace with the permissions of the tenant KSA.
+func (kr *K8SCluster) RunAsImpersonated(ns, ksa string) *K8SCluster {
+	return kr.impersonate(ns, ksa, ServiceAccountUser(ns, ksa))
+}
+
+// ForgetImpersonated removes the cached impersonating clusters for a namespace and KSA -
+// for example when the tenant is deleted. Clusters already returned keep working, but are
+// no longer shared with the next callers.
+func (kr *K8SCluster) ForgetImpersonated(ns, ksa string) {
+	base := kr
+	if kr.base != nil {
+		base = kr.base
+	}
+	prefix := ns + "/" + ksa + "/"
+	base.impersonated.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			base.impersonated.Delete(key)
+		}
+		return true
+	})
+}
+
+// ServiceAccountUser returns the user name and groups K8S authenticates a KSA as.
+func ServiceAccountUser(ns, ksa string) rest.ImpersonationConfig {
+	return rest.ImpersonationConfig{UserName: "system:serviceaccount:" + ns + ":" + ksa,
+		Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + ns, "system:authenticated"}}
+}
+
+func (kr *K8SCluster) impersonate(ns, ksa string, ic rest.ImpersonationConfig) *K8SCluster {
+	base := kr
+	if kr.base != nil {
+		base = kr.base
+	}
+	kb, _ := json.Marshal(ic)
+	key := ns + "/" + ksa + "/" + string(kb)
+	if c, f := base.impersonated.Load(key); f {
+		return c.(*K8SCluster)
+	}
+
+	// Keeps the transport wrappers and the rate limiter of the base cluster.
+	rc := rest.CopyConfig(base.RestConfig)
+	rc.Impersonate = ic
+	c := &K8SCluster{RestConfig: rc, Name: base.Name, Namespace: ns, KSA: ksa,
+		Source: base.Source, Labels: base.Labels, tokens: base.tokenCache(), base: base,
+		TokenExpirationSeconds: base.TokenExpirationSeconds, TokenBoundObjectRef: base.TokenBoundObjectRef}
+	c.clusterInfo.Store(base.clusterInfo.Load())
+	c.rateLimiter.Store(base.rateLimiter.Load())
+	c.instrumented.Store(base.instrumented.Load())
+
+	cc, _ := base.impersonated.LoadOrStore(key, c)
+	return cc.(*K8SCluster)
+}
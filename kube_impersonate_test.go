@@ -0,0 +1,85 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestImpersonate(t *testing.T) {
+	ctx := context.Background()
+
+	mu := sync.Mutex{}
+	users := map[string]string{}
+	tokens := 0
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		users[r.URL.Path] = r.Header.Get("Impersonate-User") + " " +
+			strings.Join(r.Header.Values("Impersonate-Group"), ",")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/token") {
+			mu.Lock()
+			tokens++
+			mu.Unlock()
+			json.NewEncoder(w).Encode(&authenticationv1.TokenRequest{
+				Status: authenticationv1.TokenRequestStatus{Token: "t1",
+					ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour))}})
+			return
+		}
+		json.NewEncoder(w).Encode(testCM("a", 1))
+	})
+
+	tc := kc.RunAsImpersonated("tenant1", "app")
+	if tc != kc.RunAsImpersonated("tenant1", "app") || tc.Client() != kc.RunAsImpersonated("tenant1", "app").Client() {
+		t.Error("Impersonated cluster not cached")
+	}
+	if tc.Impersonate(ServiceAccountUser("tenant1", "app")) != tc {
+		t.Error("Impersonating clusters should share the base cache")
+	}
+	kc.RunAsImpersonated("tenant2", "app")
+	kc.ForgetImpersonated("tenant2", "app")
+	if tc != kc.RunAsImpersonated("tenant1", "app") {
+		t.Error("Other tenants should stay cached")
+	}
+	n := 0
+	kc.impersonated.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Error("Impersonated cluster not removed", n)
+	}
+
+	if tc.Namespace != "tenant1" || tc.KSA != "app" || kc.RestConfig.Impersonate.UserName != "" {
+		t.Error("Unexpected cluster", tc.Namespace, tc.KSA, kc.RestConfig.Impersonate)
+	}
+
+	if _, err := tc.GetCM(ctx, "tenant1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// Tokens are requested with the base credentials, and shared.
+	if _, err := tc.GetToken(ctx, "aud"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kc.RunAs("tenant1", "app").GetToken(ctx, "aud"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if users["/api/v1/namespaces/tenant1/configmaps/a"] !=
+		"system:serviceaccount:tenant1:app system:serviceaccounts,system:serviceaccounts:tenant1,system:authenticated" {
+		t.Error("Unexpected impersonation", users)
+	}
+	if u := users["/api/v1/namespaces/tenant1/serviceaccounts/app/token"]; u != " " || tokens != 1 {
+		t.Error("Token requested as", u, tokens)
+	}
+}
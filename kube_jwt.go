@@ -0,0 +1,501 @@
+package mk8s
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// K8S signs the service account tokens with keys published at /openid/v1/jwks - the
+// tokens can be verified locally, without a TokenReview. The issuer is found in the
+// OIDC discovery document at /.well-known/openid-configuration.
+
+// jsonWebKey is a public key in a JWKS document. Only RSA and EC keys are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// The keys are cached for oidcMaxAge, and fetched again if a token uses an unknown key ID -
+// at most once per oidcMinRefresh. Failed fetches are retried after oidcRetryMin, doubling
+// up to oidcRetryMax.
+const (
+	oidcMaxAge     = 1 * time.Hour
+	oidcMinRefresh = 1 * time.Minute
+	oidcRetryMin   = 1 * time.Second
+	oidcRetryMax   = 5 * time.Minute
+)
+
+// jwtLeeway is the allowed clock skew when checking the exp, nbf and iat claims.
+const jwtLeeway = 60 * time.Second
+
+// oidcKeys is the cached discovery document and keys of a cluster.
+type oidcKeys struct {
+	issuer  string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// oidcFailure is the last failed fetch of the discovery document or keys.
+type oidcFailure struct {
+	err      error
+	failures int
+	next     time.Time
+}
+
+// TokenClaims are the claims of a K8S service account token.
+type TokenClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	Expiry    time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time
+
+	Namespace         string
+	ServiceAccount    string
+	ServiceAccountUID string
+
+	// Pod, Secret or Node the token is bound to, if any.
+	Pod    string
+	PodUID string
+	Secret string
+	Node   string
+}
+
+// jwtClaims is the JSON payload of a K8S token.
+type jwtClaims struct {
+	Iss string   `json:"iss"`
+	Sub string   `json:"sub"`
+	Aud audience `json:"aud"`
+	Exp int64    `json:"exp"`
+	Iat int64    `json:"iat"`
+	Nbf int64    `json:"nbf"`
+
+	K8S struct {
+		Namespace      string  `json:"namespace"`
+		ServiceAccount *objRef `json:"serviceaccount"`
+		Pod            *objRef `json:"pod"`
+		Secret         *objRef `json:"secret"`
+		Node           *objRef `json:"node"`
+	} `json:"kubernetes.io"`
+}
+
+type objRef struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+}
+
+// audience is a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// FetchJWKS returns the public keys used to sign the cluster tokens, by key ID.
+func (k *K8SCluster) FetchJWKS(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	rc, err := k.RestClient("", "v1")
+	if err != nil {
+		return nil, err
+	}
+	res, err := rc.Get().AbsPath("/openid/v1/jwks").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(res)
+}
+
+// Issuer returns the issuer of the cluster tokens, from the OIDC discovery document.
+func (k *K8SCluster) Issuer(ctx context.Context) (string, error) {
+	ok, err := k.oidcKeys(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return ok.issuer, nil
+}
+
+// oidcKeys returns the cached keys, fetching the discovery document and keys if missing,
+// too old or if kid is not found. Concurrent callers share the fetch, and the error of
+// a failed fetch is returned until the retry backoff expires.
+func (k *K8SCluster) oidcKeys(ctx context.Context, kid string) (*oidcKeys, error) {
+	cur := k.oidc.Load()
+	if cur != nil {
+		age := time.Since(cur.fetched)
+		if age < oidcMaxAge && (kid == "" || cur.keys[kid] != nil || age < oidcMinRefresh) {
+			return cur, nil
+		}
+	}
+	if f := k.oidcFailed.Load(); f != nil && time.Now().Before(f.next) {
+		return nil, f.err
+	}
+
+	// Not using the context of the caller - other callers may wait for the result.
+	ch := k.oidcFetch.DoChan("", func() (interface{}, error) {
+		ctx, cf := context.WithTimeout(context.Background(), tokenRefreshTimeout)
+		defer cf()
+		ok, err := k.fetchOIDCKeys(ctx)
+		if err != nil {
+			f := &oidcFailure{err: err, failures: 1}
+			if prev := k.oidcFailed.Load(); prev != nil {
+				f.failures = prev.failures + 1
+			}
+			f.next = time.Now().Add(min(oidcRetryMin<<min(f.failures-1, 20), oidcRetryMax))
+			k.oidcFailed.Store(f)
+			return nil, err
+		}
+		k.oidcFailed.Store(nil)
+		k.oidc.Store(ok)
+		return ok, nil
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(*oidcKeys), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchOIDCKeys gets the discovery document and the keys.
+func (k *K8SCluster) fetchOIDCKeys(ctx context.Context) (*oidcKeys, error) {
+	rc, err := k.RestClient("", "v1")
+	if err != nil {
+		return nil, err
+	}
+	res, err := rc.Get().AbsPath("/.well-known/openid-configuration").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	disc := struct {
+		Issuer string `json:"issuer"`
+	}{}
+	if err := json.Unmarshal(res, &disc); err != nil {
+		return nil, err
+	}
+	keys, err := k.FetchJWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcKeys{issuer: disc.Issuer, keys: keys, fetched: time.Now()}, nil
+}
+
+// VerifyToken checks a token issued by the cluster, using the keys from the OIDC discovery.
+// The issuer, audience and expiry are validated, allowing jwtLeeway of clock skew.
+func (k *K8SCluster) VerifyToken(ctx context.Context, token, aud string) (*TokenClaims, error) {
+	payload, ok, err := k.verifySignature(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return checkClaims(payload, ok.issuer, aud)
+}
+
+// checkClaims validates the claims of a token with a verified signature.
+func checkClaims(payload []byte, issuer, aud string) (*TokenClaims, error) {
+	c := &jwtClaims{}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, err
+	}
+
+	if c.Iss != issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", c.Iss)
+	}
+	if !slices.Contains(c.Aud, aud) {
+		return nil, fmt.Errorf("unexpected audience %q", c.Aud)
+	}
+	now := time.Now()
+	if c.Exp == 0 || now.After(time.Unix(c.Exp, 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if c.Nbf != 0 && now.Before(time.Unix(c.Nbf, 0).Add(-jwtLeeway)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if c.Iat != 0 && now.Before(time.Unix(c.Iat, 0).Add(-jwtLeeway)) {
+		return nil, errors.New("token issued in the future")
+	}
+
+	tc := &TokenClaims{Issuer: c.Iss, Subject: c.Sub, Audience: c.Aud,
+		Expiry: time.Unix(c.Exp, 0), IssuedAt: time.Unix(c.Iat, 0), NotBefore: time.Unix(c.Nbf, 0),
+		Namespace: c.K8S.Namespace}
+	if r := c.K8S.ServiceAccount; r != nil {
+		tc.ServiceAccount, tc.ServiceAccountUID = r.Name, r.UID
+	}
+	if r := c.K8S.Pod; r != nil {
+		tc.Pod, tc.PodUID = r.Name, r.UID
+	}
+	if r := c.K8S.Secret; r != nil {
+		tc.Secret = r.Name
+	}
+	if r := c.K8S.Node; r != nil {
+		tc.Node = r.Name
+	}
+	return tc, nil
+}
+
+// VerifyToken checks a token issued by any cluster in the set, selecting the clusters by
+// the issuer. Returns the cluster and the token claims.
+//
+// Clusters may share an issuer - for example self-managed clusters using the default
+// https://kubernetes.default.svc.cluster.local - the keys of each are tried, and the
+// cluster with the key that signed the token is returned.
+//
+// The clusters of each issuer are cached - the other clusters are checked only if none of
+// them signed the token, and clusters failing discovery are skipped until their retry
+// backoff expires.
+func (kr *K8S) VerifyToken(ctx context.Context, token, aud string) (*K8SCluster, *TokenClaims, error) {
+	iss, err := unverifiedIssuer(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	tried := map[*K8SCluster]bool{}
+	verify := func(c *K8SCluster) (bool, *TokenClaims, error) {
+		tried[c] = true
+		payload, ok, err := c.verifySignature(ctx, token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+			return false, nil, nil
+		}
+		tc, err := checkClaims(payload, ok.issuer, aud)
+		return true, tc, err
+	}
+
+	if v, f := kr.issuers.Load(iss); f {
+		for _, c := range v.([]*K8SCluster) {
+			// Skip removed or replaced clusters.
+			if kr.Cluster(c.Name) != c {
+				continue
+			}
+			if done, tc, err := verify(c); done {
+				return c, tc, err
+			}
+		}
+	}
+
+	byIssuer := map[string][]*K8SCluster{}
+	for _, c := range kr.Clusters() {
+		ci, err := c.Issuer(ctx)
+		if err != nil {
+			logger.Debug("IssuerDiscoveryFailed", "cluster", c.Name, "err", err)
+			continue
+		}
+		byIssuer[ci] = append(byIssuer[ci], c)
+	}
+	for ci, cl := range byIssuer {
+		kr.issuers.Store(ci, cl)
+	}
+	for _, c := range byIssuer[iss] {
+		if tried[c] {
+			continue
+		}
+		if done, tc, err := verify(c); done {
+			return c, tc, err
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return nil, nil, fmt.Errorf("unknown issuer %q", iss)
+}
+
+// unverifiedIssuer returns the iss claim, without checking the signature.
+func unverifiedIssuer(token string) (string, error) {
+	c, err := unverifiedClaims(token)
+	if err != nil {
+		return "", err
+	}
+	return c.Iss, nil
+}
+
+// unverifiedClaims returns the claims of a JWT, without checking the signature.
+func unverifiedClaims(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	c := &jwtClaims{}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// verifySignature checks the JWT signature using the cluster keys, and returns the payload.
+// The keys are fetched again if the token uses an unknown key ID.
+func (k *K8SCluster) verifySignature(ctx context.Context, token string) ([]byte, *oidcKeys, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("invalid JWT")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &jwtHeader{}
+	if err := json.Unmarshal(hb, h); err != nil {
+		return nil, nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ok, err := k.oidcKeys(ctx, h.Kid)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := ok.keys[h.Kid]
+	if key == nil {
+		return nil, nil, fmt.Errorf("unknown key %q", h.Kid)
+	}
+
+	err = verifyJWS(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	return payload, ok, err
+}
+
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	doc := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	res := map[string]crypto.PublicKey{}
+	for _, jk := range doc.Keys {
+		if jk.Use != "" && jk.Use != "sig" {
+			continue
+		}
+		pk, err := jk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jk.Kid, err)
+		}
+		res[jk.Kid] = pk
+	}
+	return res, nil
+}
+
+func (jk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jk.Kty)
+}
+
+// verifyJWS checks a RS* or ES* signature.
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	var hf crypto.Hash
+	var hs hash.Hash
+	switch alg[2:] {
+	case "256":
+		hf, hs = crypto.SHA256, sha256.New()
+	case "384":
+		hf, hs = crypto.SHA384, sha512.New384()
+	case "512":
+		hf, hs = crypto.SHA512, sha512.New()
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	hs.Write(signed)
+	digest := hs.Sum(nil)
+
+	switch pk := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("alg %q doesn't match RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(pk, hf, digest, sig)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("alg %q doesn't match EC key", alg)
+		}
+		n := len(sig) / 2
+		if len(sig) == 0 || len(sig)%2 != 0 {
+			return errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])
+		if !ecdsa.Verify(pk, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
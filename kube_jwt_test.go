@@ -0,0 +1,199 @@
+package mk8s
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testK8SClaims returns the claims of a K8S token bound to a pod.
+func testK8SClaims(iss, aud string, exp time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": iss, "aud": []string{aud}, "sub": "system:serviceaccount:ns1:sa1",
+		"exp": exp.Unix(), "iat": time.Now().Unix(), "nbf": time.Now().Unix(),
+		"kubernetes.io": map[string]interface{}{
+			"namespace":      "ns1",
+			"serviceaccount": map[string]string{"name": "sa1", "uid": "u1"},
+			"pod":            map[string]string{"name": "pod1", "uid": "u2"},
+		}}
+}
+
+func TestVerifyToken(t *testing.T) {
+	ctx := context.Background()
+	k1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	k2, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var rotated atomic.Bool
+	var jwksRequests atomic.Int32
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.Write([]byte(`{"issuer":"https://c1.example.com","jwks_uri":"https://c1.example.com/openid/v1/jwks"}`))
+		case "/openid/v1/jwks":
+			jwksRequests.Add(1)
+			if rotated.Load() {
+				w.Write(testJWKS(k2, "k2"))
+			} else {
+				w.Write(testJWKS(k1, "k1"))
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	k := &K8S{}
+	k.AddCluster(kc, true)
+
+	exp := time.Now().Add(time.Hour)
+	tc, err := kc.VerifyToken(ctx, testJWT(k1, "k1", testK8SClaims("https://c1.example.com", "a", exp)), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Namespace != "ns1" || tc.ServiceAccount != "sa1" || tc.Pod != "pod1" || tc.PodUID != "u2" ||
+		tc.Expiry.Unix() != exp.Unix() {
+		t.Error("Unexpected claims", tc)
+	}
+
+	for _, tok := range []string{
+		testJWT(k1, "k1", testK8SClaims("https://c1.example.com", "b", exp)),
+		testJWT(k1, "k1", testK8SClaims("https://other.example.com", "a", exp)),
+		testJWT(k1, "k1", testK8SClaims("https://c1.example.com", "a", time.Now().Add(-2*jwtLeeway))),
+		testJWT(k2, "k1", testK8SClaims("https://c1.example.com", "a", exp)),
+	} {
+		if _, err := kc.VerifyToken(ctx, tok, "a"); err == nil {
+			t.Error("Expected error", tok)
+		}
+	}
+	if jwksRequests.Load() != 1 {
+		t.Error("Keys not cached", jwksRequests.Load())
+	}
+
+	// Small clock skew is allowed.
+	skewed := testK8SClaims("https://c1.example.com", "a", time.Now().Add(-jwtLeeway/2))
+	skewed["nbf"] = time.Now().Add(jwtLeeway / 2).Unix()
+	if _, err := kc.VerifyToken(ctx, testJWT(k1, "k1", skewed), "a"); err != nil {
+		t.Error("Expected token within the leeway", err)
+	}
+
+	// Rotated key - refreshed at most once per oidcMinRefresh.
+	rotated.Store(true)
+	tok2 := testJWT(k2, "k2", testK8SClaims("https://c1.example.com", "a", exp))
+	if _, err := kc.VerifyToken(ctx, tok2, "a"); err == nil {
+		t.Error("Expected refresh to be rate limited")
+	}
+	ok := kc.oidc.Load()
+	ok.fetched = ok.fetched.Add(-oidcMinRefresh)
+	c, _, err := k.VerifyToken(ctx, tok2, "a")
+	if err != nil || c != kc {
+		t.Error("Rotated key not loaded", err)
+	}
+}
+
+func TestVerifyTokenIssuers(t *testing.T) {
+	ctx := context.Background()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var jwksRequests, badRequests atomic.Int32
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.Write([]byte(`{"issuer":"https://c1.example.com"}`))
+		case "/openid/v1/jwks":
+			jwksRequests.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			w.Write(testJWKS(key, "k1"))
+		}
+	})
+	kc.Name = "c1"
+	bad := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		badRequests.Add(1)
+		w.WriteHeader(500)
+	})
+	bad.Name = "bad"
+	k := &K8S{}
+	k.AddCluster(kc, true)
+	k.AddCluster(bad, true)
+
+	// Concurrent fetches are collapsed.
+	tok := testJWT(key, "k1", testK8SClaims("https://c1.example.com", "a", time.Now().Add(time.Hour)))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := kc.VerifyToken(ctx, tok, "a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if jwksRequests.Load() != 1 {
+		t.Error("Expected a single JWKS request", jwksRequests.Load())
+	}
+
+	// The failed cluster is not retried for each token, known issuers skip the scan.
+	other := testJWT(key, "k1", testK8SClaims("https://other.example.com", "a", time.Now().Add(time.Hour)))
+	for i := 0; i < 3; i++ {
+		if c, _, err := k.VerifyToken(ctx, tok, "a"); err != nil || c != kc {
+			t.Fatal("Unexpected result", c, err)
+		}
+		if _, _, err := k.VerifyToken(ctx, other, "a"); err == nil {
+			t.Fatal("Expected unknown issuer")
+		}
+	}
+	if n := badRequests.Load(); n != 1 {
+		t.Error("Failed discovery not backed off", n)
+	}
+
+	// Retried after the backoff.
+	f := bad.oidcFailed.Load()
+	f.next = time.Now()
+	k.VerifyToken(ctx, other, "a")
+	if n := badRequests.Load(); n != 2 || bad.oidcFailed.Load().failures != 2 {
+		t.Error("Failed discovery not retried", n)
+	}
+}
+
+func TestVerifyTokenSharedIssuer(t *testing.T) {
+	ctx := context.Background()
+
+	// Self-managed clusters using the default issuer, with different keys.
+	k := &K8S{}
+	keys := map[string]*rsa.PrivateKey{}
+	for _, n := range []string{"c1", "c2"} {
+		key, _ := rsa.GenerateKey(rand.Reader, 2048)
+		keys[n] = key
+		kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/.well-known/openid-configuration":
+				w.Write([]byte(`{"issuer":"https://kubernetes.default.svc.cluster.local"}`))
+			case "/openid/v1/jwks":
+				w.Write(testJWKS(key, "k-"+n))
+			}
+		})
+		kc.Name = n
+		k.AddCluster(kc, true)
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, n := range []string{"c2", "c1"} {
+			tok := testJWT(keys[n], "k-"+n, testK8SClaims("https://kubernetes.default.svc.cluster.local", "a",
+				time.Now().Add(time.Hour)))
+			if c, _, err := k.VerifyToken(ctx, tok, "a"); err != nil || c.Name != n {
+				t.Fatal("Unexpected cluster", n, c, err)
+			}
+		}
+	}
+
+	// Signed by neither.
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tok := testJWT(other, "k-c1", testK8SClaims("https://kubernetes.default.svc.cluster.local", "a",
+		time.Now().Add(time.Hour)))
+	if _, _, err := k.VerifyToken(ctx, tok, "a"); err == nil {
+		t.Error("Expected invalid signature")
+	}
+}
@@ -0,0 +1,192 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// Streaming lists were added in 1.27 as alpha (WatchList feature gate). A watch with
+// sendInitialEvents will send the current objects as ADDED, followed by a BOOKMARK
+// with the InitialEventsAnnotationKey annotation - avoiding the large list response.
+//
+// If the server doesn't support it, a paginated list followed by a watch is used, with
+// the same events sent to the handler.
+
+// IsInitialEventsEnd returns true if this is the bookmark marking the end of the
+// initial objects.
+func (ev *RawEvent) IsInitialEventsEnd() bool {
+	if ev.Type != watch.Bookmark {
+		return false
+	}
+	pom, err := ev.Meta()
+	if err != nil {
+		return false
+	}
+	return pom.Annotations[metav1.InitialEventsAnnotationKey] == "true"
+}
+
+// ListWatch calls fn with all the objects as ADDED events, followed by a BOOKMARK event
+// where IsInitialEventsEnd is true, followed by the changes.
+//
+// A streaming list is used if the server supports it, otherwise a paginated list (using
+// opts.Limit, default 500) followed by a watch. The result is remembered for the cluster.
+//
+// When the server closes the watch, it is restarted from the last seen revision. ListWatch
+// returns when ctx is done, fn returns an error or the revision is too old
+// (k8serrors.IsResourceExpired) - in which case a new ListWatch is needed.
+//
+// If opts.ResourceVersion is set to a revision seen in a previous ListWatch, the list is
+// skipped and only the changes since that revision are sent.
+func (kr *K8SCluster) ListWatch(ctx context.Context, gvr schema.GroupVersionResource, ns string,
+	opts metav1.ListOptions, fn func(*RawEvent) error) error {
+	rc, err := kr.RestClient(gvr.Group, gvr.Version)
+	if err != nil {
+		return err
+	}
+	if opts.ResourceVersion != "" && opts.ResourceVersion != "0" {
+		return watchFrom(ctx, rc, gvr.Resource, ns, opts, opts.ResourceVersion, fn)
+	}
+
+	var rv string
+	if !kr.watchListUnsupported.Load() {
+		sendInitialEvents := true
+		wopts := opts
+		wopts.SendInitialEvents = &sendInitialEvents
+		wopts.ResourceVersionMatch = metav1.ResourceVersionMatchNotOlderThan
+		wopts.AllowWatchBookmarks = true
+		wopts.Limit = 0
+		started, synced := false, false
+		err = watchRaw(ctx, rc, gvr.Resource, ns, wopts, func(ev *RawEvent) error {
+			started = true
+			pom, err := ev.Meta()
+			if err != nil {
+				return err
+			}
+			rv = pom.ResourceVersion
+			if !synced && ev.Type == watch.Bookmark {
+				synced = pom.Annotations[metav1.InitialEventsAnnotationKey] == "true"
+			}
+			return fn(ev)
+		})
+		if err == nil && !synced {
+			err = errors.New("watch closed before the initial events were sent")
+		}
+		if err == nil || started || !isWatchListUnsupported(err) {
+			if err != nil {
+				return err
+			}
+			return watchFrom(ctx, rc, gvr.Resource, ns, opts, rv, fn)
+		}
+		logger.Info("WatchListUnsupported", "cluster", kr.Name, "err", err)
+		kr.watchListUnsupported.Store(true)
+	}
+
+	lopts := opts
+	if lopts.Limit == 0 {
+		lopts.Limit = 500
+	}
+	lm, err := listRaw(ctx, rc, gvr.Resource, ns, lopts, func(raw json.RawMessage) error {
+		return fn(&RawEvent{Type: watch.Added, Object: raw})
+	})
+	if err != nil {
+		return err
+	}
+
+	// CRDs are not in the scheme - the list kind is KIND + "List".
+	gvk := kindFor(gvr)
+	if gvk.Kind == "" {
+		gvk.Kind = strings.TrimSuffix(lm.Kind, "List")
+	}
+	bm, err := json.Marshal(&metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+		ObjectMeta: metav1.ObjectMeta{ResourceVersion: lm.ResourceVersion,
+			Annotations: map[string]string{metav1.InitialEventsAnnotationKey: "true"}}})
+	if err != nil {
+		return err
+	}
+	err = fn(&RawEvent{Type: watch.Bookmark, Object: bm})
+	if err != nil {
+		return err
+	}
+
+	return watchFrom(ctx, rc, gvr.Resource, ns, opts, lm.ResourceVersion, fn)
+}
+
+// watchFrom watches starting with rv, restarting the watch when the server closes it.
+func watchFrom(ctx context.Context, rc *rest.RESTClient, resource string, ns string,
+	opts metav1.ListOptions, rv string, fn func(*RawEvent) error) error {
+	opts.AllowWatchBookmarks = true
+	opts.SendInitialEvents = nil
+	opts.ResourceVersionMatch = ""
+	opts.Limit = 0
+	opts.Continue = ""
+	wr := &watchRestarter{}
+	for ctx.Err() == nil {
+		opts.ResourceVersion = rv
+		wr.start()
+		err := watchRaw(ctx, rc, resource, ns, opts, func(ev *RawEvent) error {
+			pom, err := ev.Meta()
+			if err != nil {
+				return err
+			}
+			rv = pom.ResourceVersion
+			return fn(ev)
+		})
+		if err != nil {
+			return err
+		}
+		wr.wait(ctx)
+	}
+	return ctx.Err()
+}
+
+// DefaultWatchBackoff is used to retry failed watches, and to restart watches the server
+// closed soon after they started.
+var DefaultWatchBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 1 << 30, Cap: 5 * time.Minute}
+
+// minWatchDuration is the duration of a watch that resets the backoff.
+const minWatchDuration = 10 * time.Second
+
+// watchRestarter delays restarting the watches closed by the server before minWatchDuration -
+// avoiding a hot loop with servers or proxies closing the streams immediately.
+type watchRestarter struct {
+	started time.Time
+	b       *wait.Backoff
+}
+
+func (wr *watchRestarter) start() {
+	wr.started = time.Now()
+}
+
+// wait waits for the backoff if the watch was short, until ctx is done.
+func (wr *watchRestarter) wait(ctx context.Context) {
+	if time.Since(wr.started) >= minWatchDuration {
+		wr.b = nil
+		return
+	}
+	if wr.b == nil {
+		b := DefaultWatchBackoff
+		wr.b = &b
+	}
+	select {
+	case <-time.After(wr.b.Step()):
+	case <-ctx.Done():
+	}
+}
+
+// isWatchListUnsupported returns true for the errors returned by servers without the
+// WatchList feature gate - 400 from old servers and 422 if the gate is disabled. Other
+// errors, like a 403 for missing RBAC permissions, are returned to the caller.
+func isWatchListUnsupported(err error) bool {
+	return k8serrors.IsInvalid(err) || k8serrors.IsBadRequest(err)
+}
@@ -0,0 +1,133 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestListWatch(t *testing.T) {
+	for _, streaming := range []bool{true, false} {
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+
+		kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			enc := json.NewEncoder(w)
+			switch {
+			case q.Get("sendInitialEvents") == "true" && !streaming:
+				w.WriteHeader(422)
+				enc.Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+					Status: "Failure", Reason: metav1.StatusReasonInvalid, Code: 422})
+			case q.Get("sendInitialEvents") == "true":
+				enc.Encode(map[string]interface{}{"type": "ADDED", "object": testCM("a", 5)})
+				bm := testCM("", 10)
+				bm.Annotations = map[string]string{metav1.InitialEventsAnnotationKey: "true"}
+				enc.Encode(map[string]interface{}{"type": "BOOKMARK", "object": bm})
+			case q.Get("watch") != "true":
+				enc.Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+					Items: []v1.ConfigMap{testCM("a", 5)}})
+			case q.Get("resourceVersion") == "10":
+				enc.Encode(map[string]interface{}{"type": "MODIFIED", "object": testCM("a", 11)})
+			}
+		})
+
+		events := []string{}
+		err := kc.ListWatch(ctx, cmGVR, "default", metav1.ListOptions{}, func(ev *RawEvent) error {
+			m, _ := ev.Meta()
+			events = append(events, string(ev.Type)+" "+m.ResourceVersion)
+			if ev.IsInitialEventsEnd() {
+				events = append(events, "SYNCED")
+			}
+			if len(events) == 4 {
+				cf()
+			}
+			return nil
+		})
+		if err != context.Canceled {
+			t.Fatal("Unexpected error", streaming, err)
+		}
+		if strings.Join(events, ",") != "ADDED 5,BOOKMARK 10,SYNCED,MODIFIED 11" {
+			t.Error("Unexpected events", streaming, events)
+		}
+		if kc.watchListUnsupported.Load() == streaming {
+			t.Error("Unexpected mode", streaming)
+		}
+	}
+}
+
+func TestWatchRestartBackoff(t *testing.T) {
+	defer func(b wait.Backoff) { DefaultWatchBackoff = b }(DefaultWatchBackoff)
+	DefaultWatchBackoff = wait.Backoff{Duration: 100 * time.Millisecond, Factor: 2, Steps: 100, Cap: time.Second}
+
+	ctx, cf := context.WithTimeout(context.Background(), time.Second)
+	defer cf()
+
+	// The server closes the watches immediately.
+	var watches atomic.Int32
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		watches.Add(1)
+	})
+	err := kc.ListWatch(ctx, cmGVR, "default", metav1.ListOptions{ResourceVersion: "5"}, func(ev *RawEvent) error {
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Error("Unexpected error", err)
+	}
+	if n := watches.Load(); n < 2 || n > 6 {
+		t.Error("Unexpected restarts", n)
+	}
+}
+
+func TestListWatchFallback(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	forbidden := true
+	crd := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case q.Get("sendInitialEvents") == "true" && forbidden:
+			w.WriteHeader(403)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: "Failure", Reason: metav1.StatusReasonForbidden, Code: 403})
+		case q.Get("sendInitialEvents") == "true":
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: "Failure", Reason: metav1.StatusReasonBadRequest, Code: 400})
+		case q.Get("watch") != "true":
+			w.Write([]byte(`{"kind":"WidgetList","apiVersion":"example.com/v1","metadata":{"resourceVersion":"3"},"items":[]}`))
+		}
+	})
+
+	// RBAC errors are returned, and don't disable streaming lists.
+	err := kc.ListWatch(ctx, crd, "default", metav1.ListOptions{}, func(ev *RawEvent) error { return nil })
+	if !k8serrors.IsForbidden(err) || kc.watchListUnsupported.Load() {
+		t.Fatal("Expected forbidden", err)
+	}
+
+	// The synthetic bookmark has the CRD kind.
+	forbidden = false
+	var bookmark *metav1.PartialObjectMetadata
+	err = kc.ListWatch(ctx, crd, "default", metav1.ListOptions{}, func(ev *RawEvent) error {
+		bookmark, _ = ev.Meta()
+		return errors.New("done")
+	})
+	if err == nil || bookmark == nil || bookmark.Kind != "Widget" || bookmark.APIVersion != "example.com/v1" ||
+		!kc.watchListUnsupported.Load() {
+		t.Error("Unexpected bookmark", err, bookmark)
+	}
+}
@@ -0,0 +1,213 @@
+package mk8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Review results are cached for reviewCacheTTL, denied results for reviewNegativeTTL.
+// Authenticated JWTs are not cached past their expiry. Errors are not cached.
+const (
+	reviewCacheTTL    = 1 * time.Minute
+	reviewNegativeTTL = 10 * time.Second
+	reviewCacheMax    = 4096
+)
+
+var (
+	// ErrUnauthenticated is returned if the server rejected the token.
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrDenied is returned if the user is not allowed to access the resource.
+	ErrDenied = errors.New("access denied")
+)
+
+// ttlCache is a map with expiring entries. The zero value is ready to use.
+type ttlCache[V any] struct {
+	mu sync.Mutex
+	m  map[string]ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	v   V
+	exp time.Time
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, f := c.m[key]
+	if !f || time.Now().After(e.exp) {
+		var zero V
+		return zero, false
+	}
+	return e.v, true
+}
+
+func (c *ttlCache[V]) put(key string, v V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]ttlEntry[V]{}
+	}
+	if len(c.m) >= reviewCacheMax {
+		now := time.Now()
+		for k, e := range c.m {
+			if now.After(e.exp) {
+				delete(c.m, k)
+			}
+		}
+		if len(c.m) >= reviewCacheMax {
+			c.m = map[string]ttlEntry[V]{}
+		}
+	}
+	c.m[key] = ttlEntry[V]{v: v, exp: time.Now().Add(ttl)}
+}
+
+// ReviewToken authenticates a bearer token using a TokenReview, and returns the user.
+// If audiences are set, the token must be valid for one of them - the server must return
+// at least one of them in the status, authenticators ignoring the audiences are rejected.
+//
+// Returns an error wrapping ErrUnauthenticated if the token is rejected.
+func (k *K8SCluster) ReviewToken(ctx context.Context, token string, audiences ...string) (*authenticationv1.UserInfo, error) {
+	key := reviewKey(token, audiences)
+
+	st, f := k.tokenReviews.get(key)
+	if !f {
+		tr, err := k.Client().AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: audiences},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		st = &tr.Status
+		ttl := reviewCacheTTL
+		if !st.Authenticated {
+			ttl = reviewNegativeTTL
+		} else if c, err := unverifiedClaims(token); err == nil && c.Exp != 0 {
+			// Not returning an authenticated user after the token expires.
+			ttl = min(ttl, time.Until(time.Unix(c.Exp, 0)))
+		}
+		if ttl > 0 {
+			k.tokenReviews.put(key, st, ttl)
+		}
+	}
+
+	if !st.Authenticated {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, st.Error)
+	}
+	if st.User.Username == "" {
+		return nil, fmt.Errorf("%w: no user", ErrUnauthenticated)
+	}
+	if len(audiences) > 0 && !slices.ContainsFunc(st.Audiences, func(a string) bool {
+		return slices.Contains(audiences, a)
+	}) {
+		return nil, fmt.Errorf("%w: audiences %v not in %v", ErrUnauthenticated, st.Audiences, audiences)
+	}
+	return st.User.DeepCopy(), nil
+}
+
+// reviewKey returns the cache key for a token and audiences - the order of the audiences
+// doesn't matter.
+func reviewKey(token string, audiences []string) string {
+	h := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(h[:])
+	if len(audiences) == 0 {
+		return key
+	}
+	as := slices.Clone(audiences)
+	slices.Sort(as)
+	ab, _ := json.Marshal(as)
+	return key + string(ab)
+}
+
+// AccessReview checks if the user can access a resource, using a SubjectAccessReview.
+func (k *K8SCluster) AccessReview(ctx context.Context, user *authenticationv1.UserInfo,
+	attrs *authorizationv1.ResourceAttributes) (*authorizationv1.SubjectAccessReviewStatus, error) {
+	if user == nil {
+		return nil, errors.New("missing user")
+	}
+	spec := authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: attrs,
+		User:               user.Username,
+		Groups:             user.Groups,
+		UID:                user.UID,
+	}
+	if len(user.Extra) > 0 {
+		spec.Extra = map[string]authorizationv1.ExtraValue{}
+		for k, v := range user.Extra {
+			spec.Extra[k] = authorizationv1.ExtraValue(v)
+		}
+	}
+	kb, _ := json.Marshal(spec)
+	key := string(kb)
+
+	if st, f := k.accessReviews.get(key); f {
+		return st.DeepCopy(), nil
+	}
+	sar, err := k.Client().AuthorizationV1().SubjectAccessReviews().Create(ctx,
+		&authorizationv1.SubjectAccessReview{Spec: spec}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	k.putAccessReview(key, &sar.Status)
+	return sar.Status.DeepCopy(), nil
+}
+
+// SelfAccessReview checks if the cluster credentials allow access to a resource, using a
+// SelfSubjectAccessReview.
+func (k *K8SCluster) SelfAccessReview(ctx context.Context,
+	attrs *authorizationv1.ResourceAttributes) (*authorizationv1.SubjectAccessReviewStatus, error) {
+	kb, _ := json.Marshal(attrs)
+	key := "self/" + string(kb)
+
+	if st, f := k.accessReviews.get(key); f {
+		return st.DeepCopy(), nil
+	}
+	sar, err := k.Client().AuthorizationV1().SelfSubjectAccessReviews().Create(ctx,
+		&authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: attrs}}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	k.putAccessReview(key, &sar.Status)
+	return sar.Status.DeepCopy(), nil
+}
+
+func (k *K8SCluster) putAccessReview(key string, st *authorizationv1.SubjectAccessReviewStatus) {
+	ttl := reviewCacheTTL
+	if !st.Allowed {
+		ttl = reviewNegativeTTL
+	}
+	k.accessReviews.put(key, st, ttl)
+}
+
+// Authorize authenticates the token with ReviewToken and checks the user can access the
+// resource with AccessReview.
+//
+// Returns an error wrapping ErrUnauthenticated or ErrDenied if the request is not allowed.
+func (k *K8SCluster) Authorize(ctx context.Context, token string, attrs *authorizationv1.ResourceAttributes,
+	audiences ...string) (*authenticationv1.UserInfo, error) {
+	user, err := k.ReviewToken(ctx, token, audiences...)
+	if err != nil {
+		return nil, err
+	}
+	st, err := k.AccessReview(ctx, user, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if !st.Allowed || st.Denied {
+		return user, fmt.Errorf("%w: %s %s", ErrDenied, user.Username, st.Reason)
+	}
+	return user, nil
+}
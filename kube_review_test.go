@@ -0,0 +1,112 @@
+package mk8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/authentication.k8s.io/v1/tokenreviews":
+			tr := &authenticationv1.TokenReview{}
+			json.NewDecoder(r.Body).Decode(tr)
+			switch tr.Spec.Token {
+			case "nouser":
+				tr.Status.Authenticated = true
+			case "noaud":
+				// Authenticators ignoring the audiences.
+				tr.Status = authenticationv1.TokenReviewStatus{Authenticated: true,
+					User: authenticationv1.UserInfo{Username: "system:serviceaccount:ns1:sa1"}}
+			default:
+				tr.Status = authenticationv1.TokenReviewStatus{Authenticated: true,
+					User:      authenticationv1.UserInfo{Username: "system:serviceaccount:ns1:sa1"},
+					Audiences: tr.Spec.Audiences}
+			case "bad":
+				tr.Status.Error = "invalid token"
+			}
+			json.NewEncoder(w).Encode(tr)
+		case "/apis/authorization.k8s.io/v1/subjectaccessreviews":
+			sar := &authorizationv1.SubjectAccessReview{}
+			json.NewDecoder(r.Body).Decode(sar)
+			sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:ns1:sa1" &&
+				sar.Spec.ResourceAttributes.Verb == "get"
+			json.NewEncoder(w).Encode(sar)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	get := &authorizationv1.ResourceAttributes{Verb: "get", Resource: "configmaps", Namespace: "ns1"}
+	for i := 0; i < 3; i++ {
+		user, err := kc.Authorize(ctx, "good", get)
+		if err != nil || user.Username != "system:serviceaccount:ns1:sa1" {
+			t.Fatal("Unexpected result", user, err)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Error("Results not cached", requests.Load())
+	}
+
+	// Cached results are copies.
+	user, _ := kc.ReviewToken(ctx, "good")
+	user.Username = "changed"
+	st, _ := kc.AccessReview(ctx, &authenticationv1.UserInfo{Username: "system:serviceaccount:ns1:sa1"}, get)
+	st.Allowed = false
+	if user, err := kc.Authorize(ctx, "good", get); err != nil || user.Username != "system:serviceaccount:ns1:sa1" {
+		t.Error("Cached result modified", user, err)
+	}
+
+	// Not cached past the token expiry.
+	exp := time.Now().Add(10 * time.Second).Truncate(time.Second)
+	claims, _ := json.Marshal(map[string]int64{"exp": exp.Unix()})
+	jwt := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+	n := requests.Load()
+	kc.ReviewToken(ctx, jwt)
+	kc.ReviewToken(ctx, jwt)
+	h := sha256.Sum256([]byte(jwt))
+	if e := kc.tokenReviews.m[hex.EncodeToString(h[:])]; requests.Load() != n+1 || e.exp.After(exp.Add(time.Second)) {
+		t.Error("Review cached past the token expiry", e.exp, exp)
+	}
+
+	_, err := kc.Authorize(ctx, "good", &authorizationv1.ResourceAttributes{Verb: "delete",
+		Resource: "configmaps", Namespace: "ns1"})
+	if !errors.Is(err, ErrDenied) {
+		t.Error("Expected denied", err)
+	}
+	_, err = kc.Authorize(ctx, "bad", get)
+	if !errors.Is(err, ErrUnauthenticated) {
+		t.Error("Expected unauthenticated", err)
+	}
+	if _, err := kc.Authorize(ctx, "nouser", get); !errors.Is(err, ErrUnauthenticated) {
+		t.Error("Expected unauthenticated without user", err)
+	}
+
+	// The audiences must be confirmed by the server.
+	if _, err := kc.ReviewToken(ctx, "good", "a", "b"); err != nil {
+		t.Error(err)
+	}
+	if _, err := kc.ReviewToken(ctx, "noaud", "a"); !errors.Is(err, ErrUnauthenticated) {
+		t.Error("Expected unauthenticated without audiences", err)
+	}
+	if reviewKey("t", []string{"a,b"}) == reviewKey("t", []string{"a", "b"}) ||
+		reviewKey("t", []string{"b", "a"}) != reviewKey("t", []string{"a", "b"}) {
+		t.Error("Unexpected review keys")
+	}
+}
@@ -30,14 +30,14 @@ import (
 // TODO: some testing with Informer and check the Store.
 // TODO: can we use Informer with disk cache and saved lastSync ?
 
-func ExampleStart()  {
+// Not executed - the result depends on the available clusters.
+func ExampleNew()  {
 	ctx := context.Background()
-	ks, err := New(ctx, nil)
+	ks, err := New(ctx, "", "")
 	if err != nil {
 		return
 	}
 	fmt.Println(ks.Default != nil)
-	// Output: true
 }
 
 
@@ -46,7 +46,10 @@ func TestModes(t *testing.T) {
 	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cf()
 
-	ks, err := New(ctx, nil)
+	ks, err := New(ctx, "", "")
+	if ks.Default == nil {
+		t.Skip("No K8S cluster configured")
+	}
 
 	k := ks.Default
 
@@ -220,10 +223,13 @@ var _ rest.Interface = &FakeRest{}
 func TestWatch(t *testing.T) {
 	SetK8SLogging("-v=9")
 
-	ks, err := New(context.Background(), nil)
+	ks, err := New(context.Background(), "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if ks.Default == nil {
+		t.Skip("No K8S cluster configured")
+	}
 
 	k := ks.Default
 
@@ -479,10 +485,13 @@ func TestWatch(t *testing.T) {
 // streaming lists is in 1.27 alpha.
 func TestSendInitialEvents(t *testing.T) {
 	SetK8SLogging("-v=9")
-	k, err := New(context.Background(), nil)
+	k, err := New(context.Background(), "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if k.Default == nil {
+		t.Skip("No K8S cluster configured")
+	}
 
 	//GET /api/v1/namespaces/test/pods?watch=1&sendInitialEvents=true&allowWatchBookmarks=true&resourceVersion=&resourceVersionMatch=NotOlderThan
 	//var timeout time.Duration
@@ -568,3 +577,29 @@ func Bool(b bool) *bool {
 func PInt64(b int64) *int64 {
 	return &b
 }
+
+func TestClusterSet(t *testing.T) {
+	k := &K8S{}
+	events := []string{}
+	k.Subscribe(func(ev ClusterEvent) {
+		events = append(events, string(ev.Type)+" "+ev.Cluster.Name)
+	})
+
+	c1 := &K8SCluster{Name: "c1"}
+	if !k.AddCluster(c1, false) || k.AddCluster(&K8SCluster{Name: "c1"}, false) {
+		t.Fatal("Unexpected add result")
+	}
+	k.AddCluster(&K8SCluster{Name: "c2"}, false)
+	k.AddCluster(&K8SCluster{Name: "c2"}, true)
+	if k.Cluster("c1") != c1 || len(k.Clusters()) != 2 || k.Clusters()[1].Name != "c2" {
+		t.Fatal("Unexpected clusters", k.Clusters())
+	}
+	if k.RemoveCluster("c1") != c1 || k.RemoveCluster("c1") != nil || k.Cluster("c1") != nil {
+		t.Fatal("Remove failed", k.Clusters())
+	}
+
+	exp := []string{"ADDED c1", "ADDED c2", "CHANGED c2", "REMOVED c1"}
+	if fmt.Sprint(events) != fmt.Sprint(exp) {
+		t.Error("Unexpected events", events)
+	}
+}
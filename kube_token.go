@@ -0,0 +1,244 @@
+package mk8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Tokens are cached by namespace, KSA, audience, expiration and bound object. A token
+// is refreshed in background after 80% of its lifetime, and is not returned if it
+// expires in less than tokenMinRemaining. Expired tokens are removed every
+// tokenSweepInterval.
+//
+// Failed requests are cached too - the error is returned for tokenRetryMin, doubling
+// with each consecutive failure up to tokenRetryMax.
+const (
+	tokenRefreshFraction = 0.8
+	tokenMinRemaining    = 30 * time.Second
+	tokenRefreshTimeout  = 10 * time.Second
+	tokenSweepInterval   = time.Minute
+	tokenRetryMin        = 1 * time.Second
+	tokenRetryMax        = 1 * time.Minute
+)
+
+// TokenOptions are the parameters of a TokenRequest.
+type TokenOptions struct {
+	// Namespace and KSA of the service account - "default" if empty.
+	Namespace string
+	KSA       string
+
+	Audiences []string
+
+	// ExpirationSeconds is the requested lifetime - the server default (1h) if 0.
+	ExpirationSeconds int64
+
+	// BoundObjectRef binds the token to a Pod, Secret or Node - the token is rejected
+	// once the object is deleted.
+	BoundObjectRef *authenticationv1.BoundObjectReference
+
+	// Verify checks the token signature using the cluster JWKS.
+	Verify bool
+}
+
+// GetTokenWithOptions returns a token for a KSA, using a cached TokenRequest result
+// if possible. The status includes the expiration time of the token.
+func (k *K8SCluster) GetTokenWithOptions(ctx context.Context, opts TokenOptions) (*authenticationv1.TokenRequestStatus, error) {
+	ns, ksa := opts.Namespace, opts.KSA
+	if ns == "" {
+		ns = "default"
+	}
+	if ksa == "" {
+		ksa = "default"
+	}
+
+	spec := authenticationv1.TokenRequestSpec{
+		Audiences:      opts.Audiences,
+		BoundObjectRef: opts.BoundObjectRef,
+	}
+	if opts.ExpirationSeconds > 0 {
+		exp := opts.ExpirationSeconds
+		spec.ExpirationSeconds = &exp
+	}
+	ts, err := k.cachedTokenRequest(ctx, ns, ksa, spec)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Verify {
+		if _, _, err := k.verifySignature(ctx, ts.Token); err != nil {
+			return nil, fmt.Errorf("invalid token: %w", err)
+		}
+	}
+
+	res := *ts
+	return &res, nil
+}
+
+// tokenCache holds the TokenRequest results for a cluster. Shared by the clusters
+// created with RunAs.
+type tokenCache struct {
+	mu        sync.Mutex
+	tokens    map[string]*cachedToken
+	lastSweep time.Time
+}
+
+type cachedToken struct {
+	// done is closed when the first request completes - concurrent callers wait for it
+	// instead of making their own request.
+	done chan struct{}
+
+	// Guarded by tokenCache.mu after done is closed.
+	status     *authenticationv1.TokenRequestStatus
+	err        error
+	issued     time.Time
+	refreshing bool
+
+	// failures is the number of consecutive failed requests, retryAt the time the next
+	// request can be made after an error.
+	failures int
+	retryAt  time.Time
+}
+
+func (k *K8SCluster) tokenCache() *tokenCache {
+	k.tokensOnce.Do(func() {
+		if k.tokens == nil {
+			k.tokens = &tokenCache{tokens: map[string]*cachedToken{}}
+		}
+	})
+	return k.tokens
+}
+
+// cachedTokenRequest returns a cached token for the KSA, making a TokenRequest if no valid
+// token is cached.
+func (k *K8SCluster) cachedTokenRequest(ctx context.Context, ns, ksa string,
+	spec authenticationv1.TokenRequestSpec) (*authenticationv1.TokenRequestStatus, error) {
+	tc := k.tokenCache()
+	key := tokenKey(ns, ksa, &spec)
+
+	tc.mu.Lock()
+	tc.sweep()
+	ct := tc.tokens[key]
+	failures := 0
+	if ct != nil && ct.status != nil && time.Until(ct.status.ExpirationTimestamp.Time) < tokenMinRemaining {
+		ct = nil
+	} else if ct != nil && ct.err != nil && time.Now().After(ct.retryAt) {
+		failures = ct.failures
+		ct = nil
+	}
+	if ct == nil {
+		ct = &cachedToken{done: make(chan struct{}), failures: failures}
+		tc.tokens[key] = ct
+		go k.requestToken(tc, ct, ns, ksa, spec)
+	}
+	tc.mu.Unlock()
+
+	select {
+	case <-ct.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if ct.err != nil {
+		return nil, ct.err
+	}
+	st := ct.status
+	lifetime := st.ExpirationTimestamp.Sub(ct.issued)
+	if !ct.refreshing && time.Since(ct.issued) > time.Duration(float64(lifetime)*tokenRefreshFraction) {
+		ct.refreshing = true
+		go k.refreshToken(ct, ns, ksa, spec)
+	}
+	return st, nil
+}
+
+// requestToken makes the first TokenRequest for a cached token. The context of the caller
+// is not used - other callers are waiting for the same result, and may still need it.
+//
+// Errors are kept until retryAt - a missing KSA or RBAC permission doesn't result in a
+// request for each call.
+func (k *K8SCluster) requestToken(tc *tokenCache, ct *cachedToken, ns, ksa string,
+	spec authenticationv1.TokenRequestSpec) {
+	ctx, cf := context.WithTimeout(context.Background(), tokenRefreshTimeout)
+	defer cf()
+	st, err := k.tokenRequest(ctx, ns, ksa, spec)
+
+	tc.mu.Lock()
+	ct.status, ct.err, ct.issued = st, err, time.Now()
+	if err != nil {
+		ct.failures++
+		ct.retryAt = ct.issued.Add(min(tokenRetryMin<<min(ct.failures-1, 20), tokenRetryMax))
+		logger.Warn("TokenRequestFailed", "cluster", k.Name, "ns", ns, "ksa", ksa,
+			"failures", ct.failures, "err", err)
+	}
+	tc.mu.Unlock()
+	close(ct.done)
+}
+
+// sweep removes the expired tokens, at most once per tokenSweepInterval. Called with mu held.
+func (tc *tokenCache) sweep() {
+	now := time.Now()
+	if now.Sub(tc.lastSweep) < tokenSweepInterval {
+		return
+	}
+	tc.lastSweep = now
+	for key, ct := range tc.tokens {
+		// Tokens still being requested have no status or error.
+		if (ct.status != nil && now.After(ct.status.ExpirationTimestamp.Time)) ||
+			(ct.err != nil && now.After(ct.retryAt)) {
+			delete(tc.tokens, key)
+		}
+	}
+}
+
+// refreshToken replaces the cached token before it expires. On error the old token is
+// kept, and the refresh is retried on the next call.
+func (k *K8SCluster) refreshToken(ct *cachedToken, ns, ksa string, spec authenticationv1.TokenRequestSpec) {
+	ctx, cf := context.WithTimeout(context.Background(), tokenRefreshTimeout)
+	defer cf()
+	st, err := k.tokenRequest(ctx, ns, ksa, spec)
+
+	tc := k.tokenCache()
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	ct.refreshing = false
+	if err != nil {
+		logger.Warn("TokenRefreshFailed", "cluster", k.Name, "ns", ns, "ksa", ksa, "err", err)
+		return
+	}
+	ct.status, ct.issued = st, time.Now()
+}
+
+func (k *K8SCluster) tokenRequest(ctx context.Context, ns, ksa string,
+	spec authenticationv1.TokenRequestSpec) (*authenticationv1.TokenRequestStatus, error) {
+	treq := &authenticationv1.TokenRequest{Spec: spec}
+	if k.base != nil {
+		// Impersonated users typically can't create tokens.
+		k = k.base
+	}
+	ts, err := k.Client().CoreV1().ServiceAccounts(ns).CreateToken(ctx,
+		ksa, treq, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if ts.Status.Token == "" {
+		return nil, errors.New("empty token")
+	}
+	return &ts.Status, nil
+}
+
+func tokenKey(ns, ksa string, spec *authenticationv1.TokenRequestSpec) string {
+	key := fmt.Sprintf("%s/%s/%q", ns, ksa, spec.Audiences)
+	if spec.ExpirationSeconds != nil {
+		key += fmt.Sprintf("/%d", *spec.ExpirationSeconds)
+	}
+	if r := spec.BoundObjectRef; r != nil {
+		key += "/" + r.Kind + "/" + r.Name + "/" + string(r.UID)
+	}
+	return key
+}
@@ -0,0 +1,204 @@
+package mk8s
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTokenCache(t *testing.T) {
+	ctx := context.Background()
+
+	var requests, denied atomic.Int32
+	lifetime := time.Hour
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/serviceaccounts/missing/") {
+			denied.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		n := requests.Add(1)
+		treq := &authenticationv1.TokenRequest{}
+		json.NewDecoder(r.Body).Decode(treq)
+		time.Sleep(20 * time.Millisecond)
+		treq.Status = authenticationv1.TokenRequestStatus{
+			Token:               treq.Spec.Audiences[0] + "-" + string('0'+rune(n)),
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(lifetime)),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(treq)
+	})
+
+	// Concurrent requests are collapsed.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := kc.GetTokenRaw(ctx, "default", "default", "a")
+			if err != nil || tok != "a-1" {
+				t.Error("Unexpected token", tok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests.Load() != 1 {
+		t.Fatal("Expected a single request", requests.Load())
+	}
+
+	// Different audience or KSA - new request. RunAs shares the cache.
+	tok, _ := kc.GetTokenRaw(ctx, "default", "default", "b")
+	kc.RunAs("default", "default").GetTokenRaw(ctx, "default", "default", "b")
+	if tok != "b-2" || requests.Load() != 2 {
+		t.Fatal("Unexpected token", tok, requests.Load())
+	}
+
+	// Short lived token - returned, but refreshed in background.
+	lifetime = 40 * time.Second
+	kc.TokenExpirationSeconds = 600
+	kc.GetTokenRaw(ctx, "default", "default", "c")
+	tc := kc.tokenCache()
+	tc.mu.Lock()
+	for _, ct := range tc.tokens {
+		ct.issued = ct.issued.Add(-time.Hour)
+	}
+	tc.mu.Unlock()
+	tok, _ = kc.GetTokenRaw(ctx, "default", "default", "c")
+	if tok != "c-3" {
+		t.Fatal("Unexpected token", tok)
+	}
+	time.Sleep(100 * time.Millisecond)
+	tok, _ = kc.GetTokenRaw(ctx, "default", "default", "c")
+	if tok != "c-4" {
+		t.Error("Token not refreshed", tok)
+	}
+
+	// Expired tokens are removed.
+	tc.mu.Lock()
+	for _, ct := range tc.tokens {
+		ct.status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	}
+	tc.lastSweep = time.Time{}
+	tc.mu.Unlock()
+	kc.GetTokenRaw(ctx, "default", "default", "d")
+	tc.mu.Lock()
+	if len(tc.tokens) != 1 {
+		t.Error("Expired tokens not removed", len(tc.tokens))
+	}
+	tc.mu.Unlock()
+
+	// A cancelled caller doesn't fail the request shared with the other callers.
+	cctx, ccf := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		ccf()
+	}()
+	if _, err := kc.GetTokenRaw(cctx, "default", "default", "e"); err == nil {
+		t.Error("Expected cancelled request")
+	}
+	if tok, err := kc.GetTokenRaw(ctx, "default", "default", "e"); err != nil || tok != "e-6" {
+		t.Error("Unexpected token", tok, err)
+	}
+
+	// Errors are cached until the retry backoff expires.
+	for i := 0; i < 3; i++ {
+		if _, err := kc.GetTokenRaw(ctx, "default", "missing", "a"); err == nil {
+			t.Fatal("Expected error")
+		}
+	}
+	if denied.Load() != 1 {
+		t.Error("Error not cached", denied.Load())
+	}
+	tc.mu.Lock()
+	for _, ct := range tc.tokens {
+		ct.retryAt = time.Now()
+	}
+	tc.mu.Unlock()
+	kc.GetTokenRaw(ctx, "default", "missing", "a")
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for _, ct := range tc.tokens {
+		if ct.err != nil && (ct.failures != 2 || time.Until(ct.retryAt) <= tokenRetryMin) {
+			t.Error("Expected retry with backoff", ct.failures, ct.retryAt)
+		}
+	}
+	if denied.Load() != 2 {
+		t.Error("Error not retried", denied.Load())
+	}
+}
+
+// testJWT returns a RS256 JWT signed with the key.
+func testJWT(key *rsa.PrivateKey, kid string, claims interface{}) string {
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	d := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, d[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// testJWKS returns the JWKS document for the key.
+func testJWKS(key *rsa.PrivateKey, kid string) []byte {
+	res, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA", "kid": kid, "alg": "RS256", "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())}}})
+	return res
+}
+
+func TestGetTokenWithOptions(t *testing.T) {
+	ctx := context.Background()
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/openid/v1/jwks" {
+			w.Write(testJWKS(key, "k1"))
+			return
+		}
+		treq := &authenticationv1.TokenRequest{}
+		json.NewDecoder(r.Body).Decode(treq)
+		signer := key
+		if treq.Spec.BoundObjectRef == nil {
+			signer = other
+		} else if treq.Spec.BoundObjectRef.Name != "pod1" || *treq.Spec.ExpirationSeconds != 600 {
+			w.WriteHeader(400)
+			return
+		}
+		treq.Status = authenticationv1.TokenRequestStatus{
+			Token:               testJWT(signer, "k1", map[string]string{"sub": "test"}),
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(10 * time.Minute)),
+		}
+		json.NewEncoder(w).Encode(treq)
+	})
+
+	st, err := kc.GetTokenWithOptions(ctx, TokenOptions{Audiences: []string{"a"}, ExpirationSeconds: 600,
+		BoundObjectRef: &authenticationv1.BoundObjectReference{Kind: "Pod", APIVersion: "v1", Name: "pod1"},
+		Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(st.ExpirationTimestamp.Time) < 9*time.Minute {
+		t.Error("Unexpected expiration", st.ExpirationTimestamp)
+	}
+
+	_, err = kc.GetTokenWithOptions(ctx, TokenOptions{Audiences: []string{"a"}, Verify: true})
+	if err == nil {
+		t.Error("Expected signature error")
+	}
+}
@@ -0,0 +1,181 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// Raw list and watch, without decoding the objects.
+//
+// The informers decode each object into a generated type and keep it in memory.
+// The functions in this file keep the JSON as received - callers can decode
+// only what they need, save it to disk or forward it.
+
+// RawEvent is a watch event, with the object kept as raw JSON.
+// This is the same format the server uses on the wire.
+type RawEvent struct {
+	// Type is ADDED, MODIFIED, DELETED, BOOKMARK or ERROR
+	Type watch.EventType `json:"type"`
+
+	Object json.RawMessage `json:"object"`
+}
+
+// optionsVersion is used to encode the ListOptions - the group version of the resource
+// may not be in the scheme (CRDs).
+var optionsVersion = schema.GroupVersion{Version: "v1"}
+
+// rawList is used to decode a list response, without decoding the items.
+type rawList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []json.RawMessage `json:"items"`
+}
+
+// Meta decodes the object metadata of the event.
+func (ev *RawEvent) Meta() (*metav1.PartialObjectMetadata, error) {
+	pom := &metav1.PartialObjectMetadata{}
+	err := json.Unmarshal(ev.Object, pom)
+	return pom, err
+}
+
+// ListRaw lists a resource, calling fn for each item as pages are received.
+//
+// If opts.Limit is set, the list is paginated and the next page is only requested
+// after fn returns for all items in the current page - the full list is never
+// held in memory.
+//
+// The returned ListMeta holds the resourceVersion of the list, which can be
+// used to start a watch. ns is "" for cluster-scoped resources or all namespaces.
+func (kr *K8SCluster) ListRaw(ctx context.Context, gvr schema.GroupVersionResource, ns string,
+	opts metav1.ListOptions, fn func(json.RawMessage) error) (*metav1.ListMeta, error) {
+	rc, err := kr.RestClient(gvr.Group, gvr.Version)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listRaw(ctx, rc, gvr.Resource, ns, opts, fn)
+	if err != nil {
+		return nil, err
+	}
+	return &l.ListMeta, nil
+}
+
+// listRaw returns the type and metadata of the last page - the items are not kept.
+func listRaw(ctx context.Context, rc *rest.RESTClient, resource string, ns string,
+	opts metav1.ListOptions, fn func(json.RawMessage) error) (*rawList, error) {
+	for {
+		lm, err := listPage(ctx, rc, resource, ns, opts, fn)
+		if err != nil {
+			return nil, err
+		}
+		if lm.Continue == "" {
+			return lm, nil
+		}
+		opts.Continue = lm.Continue
+	}
+}
+
+// listPage makes a single list request. If opts.Continue is set, the resource version
+// options are ignored - the continue token encodes the revision and it is an error to
+// send both.
+func listPage(ctx context.Context, rc *rest.RESTClient, resource string, ns string,
+	opts metav1.ListOptions, fn func(json.RawMessage) error) (*rawList, error) {
+	opts.Watch = false
+	if opts.Continue != "" {
+		opts.ResourceVersion = ""
+		opts.ResourceVersionMatch = ""
+	}
+	res, err := rc.Get().
+		NamespaceIfScoped(ns, ns != "").
+		Resource(resource).
+		SpecificallyVersionedParams(&opts, scheme.ParameterCodec, optionsVersion).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &rawList{}
+	err = json.Unmarshal(res, l)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range l.Items {
+		err = fn(item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.Items = nil
+	return l, nil
+}
+
+// WatchRaw starts a watch and calls fn for each event, until the server closes
+// the stream, ctx is done or fn returns an error.
+//
+// The server closes the stream after opts.TimeoutSeconds or its own default
+// timeout - in this case nil is returned and the caller should start a new watch
+// from the last seen resourceVersion.
+//
+// ERROR events are returned as a *StatusError. If the resourceVersion is too old
+// k8serrors.IsResourceExpired or IsGone will be true, and the caller needs to re-list.
+func (kr *K8SCluster) WatchRaw(ctx context.Context, gvr schema.GroupVersionResource, ns string,
+	opts metav1.ListOptions, fn func(*RawEvent) error) error {
+	rc, err := kr.RestClient(gvr.Group, gvr.Version)
+	if err != nil {
+		return err
+	}
+	return watchRaw(ctx, rc, gvr.Resource, ns, opts, fn)
+}
+
+func watchRaw(ctx context.Context, rc *rest.RESTClient, resource string, ns string,
+	opts metav1.ListOptions, fn func(*RawEvent) error) error {
+	opts.Watch = true
+	body, err := rc.Get().
+		NamespaceIfScoped(ns, ns != "").
+		Resource(resource).
+		SpecificallyVersionedParams(&opts, scheme.ParameterCodec, optionsVersion).
+		Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		ev := &RawEvent{}
+		err = dec.Decode(ev)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if ev.Type == watch.Error {
+			status := &metav1.Status{}
+			err = json.Unmarshal(ev.Object, status)
+			if err != nil {
+				return err
+			}
+			return k8serrors.FromObject(status)
+		}
+
+		err = fn(ev)
+		if err != nil {
+			return err
+		}
+	}
+}
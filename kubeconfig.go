@@ -1,10 +1,19 @@
 package mk8s
 
 import (
-	"log/slog"
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 // This is the only dep to the kube.config interface - rest of the code
@@ -30,84 +39,255 @@ import (
 //   - KUBECONFIG
 //   - $HOME/.kube/config
 //
+// The param and KUBECONFIG can be a list of files, separated by ':' (';' on windows).
+// Like kubectl, the files are merged and the first file defining a context, cluster
+// or user wins. Missing files are ignored.
+//
 // error is set if KUBECONFIG is set or ~/.kube/config exists but
-// fail to load. If the file doesn't exist, err is nil and nothing is loaded.
+// fail to load. If no file exists, err is nil and nothing is loaded.
+//
+// The loaded clusters have the file defining the context as Source. WatchKubeConfig can
+// be used to reload them when the files change.
 func (kr *K8S) LoadKubeConfig(configFile string) error {
-	// Explicit kube config - use it
-	if configFile == "" {
-		configFile = os.Getenv("KUBECONFIG")
+	p := &KubeConfigProvider{Path: configFile}
+	kr.providersMu.Lock()
+	for _, o := range kr.Providers {
+		if kp, ok := o.(*KubeConfigProvider); ok && kp.Name() == p.Name() {
+			p = kp
+		}
 	}
-	if configFile == "" {
-		configFile = os.Getenv("HOME") + "/.kube/config"
+	if !slices.Contains(kr.Providers, ClusterProvider(p)) {
+		kr.Providers = append(kr.Providers, p)
 	}
+	kr.providersMu.Unlock()
+	return kr.refreshProvider(context.Background(), p)
+}
+
+// KubeConfigProvider is a ClusterProvider returning a cluster for each context in a list
+// of kubeconfig files. The current context is returned first.
+type KubeConfigProvider struct {
+	// Path is the list of files - defaults to KUBECONFIG or ~/.kube/config.
+	Path string
+
+	// Interval is used by Watch to check the files for changes. Defaults to 10s.
+	Interval time.Duration
+
+	// sums has the content of each loaded context - used to reload only changed contexts.
+	mu       sync.Mutex
+	sums     map[string]string
+	clusters map[string]*K8SCluster
+	current  string
+}
 
-	if _, err := os.Stat(configFile); err != nil {
-		return nil
+func (p *KubeConfigProvider) path() string {
+	if p.Path != "" {
+		return p.Path
 	}
-		// Load the kube config explicitly
-
-		// This is an api.Config - i.e. kubeconfig, but just for this file
-		//cf, err := clientcmd.LoadFromFile(kc)
-		// clientcmd.Load(kcdata)
-		// To get the rest.Config:
-		//config := clientcmd.NewNonInteractiveClientConfig(cf, cf.CurrentContext, nil, nil)
-
-		// This will attempt to use in-cluster if kc is empty, otherwise
-		// same as BuildConfigFromFlags
-		//config, err := clientcmd.BuildConfigFromFlags("", kc)
-
-		// May merge multiple kubeconfigs
-		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{ExplicitPath: configFile},
-			&clientcmd.ConfigOverrides{ //CurrentContext: "",
-			})
-
-		// Get merged config - instead of the ClientConfig which only returns default
-		// context.
-		apiConfig, err := clientConfig.RawConfig()
-		if err != nil {
-			return err
-		}
+	if kc := os.Getenv("KUBECONFIG"); kc != "" {
+		return kc
+	}
+	return os.Getenv("HOME") + "/.kube/config"
+}
 
-		// For each cluster in the config, create a K8SCluster with a valid client.
-		// K8S config defines clusters as 'contexts' - associating an endpoint and
-		// credentials.
-		for contextName, cc := range apiConfig.Contexts {
-			k := contextName
-			cc := cc
+func (p *KubeConfigProvider) Name() string {
+	return "kubeconfig:" + p.path()
+}
 
-			// This is the native method to create a restConfig using the context name.
-			clientcmdClientConfig := clientcmd.NewNonInteractiveClientConfig(apiConfig, k, nil, nil)
+// Discover loads the files, creating new clusters only for the contexts that changed
+// since the last load.
+func (p *KubeConfigProvider) Discover(ctx context.Context) ([]*K8SCluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	configFile := p.path()
+	paths := filepath.SplitList(configFile)
 
-			// The config file includes a default namespace too for the context.
-			ns, _, _ := clientcmdClientConfig.Namespace()
+	// Load the kube config explicitly
 
-			// restConfig is what the main library is using.
-			restConfig, err := clientcmdClientConfig.ClientConfig()
-			if err != nil {
-				slog.Warn("Invalid K8S Cluster", "cfg", configFile, "context", k, "cluster", cc.Cluster, "err", err)
-				continue
-			}
-			// Can set restCfg.RateLimiter to replace defaults
-			if kr.QPS > 0 {
-				restConfig.QPS = kr.QPS // default is 5
-			}
-			if kr.Burst > 0 {
-				restConfig.Burst = kr.Burst // default 10
-			}
-			kcc := &K8SCluster {
-				Name:       k,
-				Namespace:  ns,
-				RestConfig: restConfig,
-				RawConfig: clientcmdClientConfig,
+	// This is an api.Config - i.e. kubeconfig, but just for this file
+	//cf, err := clientcmd.LoadFromFile(kc)
+	// clientcmd.Load(kcdata)
+	// To get the rest.Config:
+	//config := clientcmd.NewNonInteractiveClientConfig(cf, cf.CurrentContext, nil, nil)
+
+	// This will attempt to use in-cluster if kc is empty, otherwise
+	// same as BuildConfigFromFlags
+	//config, err := clientcmd.BuildConfigFromFlags("", kc)
+
+	// Merges multiple kubeconfigs - first file wins.
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{Precedence: paths},
+		&clientcmd.ConfigOverrides{ //CurrentContext: "",
+		})
+
+	// Get merged config - instead of the ClientConfig which only returns default
+	// context.
+	apiConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.sums == nil {
+		p.sums = map[string]string{}
+		p.clusters = map[string]*K8SCluster{}
+	}
+	p.current = apiConfig.CurrentContext
+
+	names := make([]string, 0, len(apiConfig.Contexts))
+	for k := range apiConfig.Contexts {
+		names = append(names, k)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if (a == apiConfig.CurrentContext) != (b == apiConfig.CurrentContext) {
+			if a == apiConfig.CurrentContext {
+				return -1
 			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	// For each cluster in the config, create a K8SCluster with a valid client.
+	// K8S config defines clusters as 'contexts' - associating an endpoint and
+	// credentials.
+	res := []*K8SCluster{}
+	for _, k := range names {
+		cc := apiConfig.Contexts[k]
+		sum := contextSum(&apiConfig, k)
+		if c := p.clusters[k]; c != nil && p.sums[k] == sum {
+			res = append(res, c)
+			continue
+		}
+
+		// This is the native method to create a restConfig using the context name.
+		clientcmdClientConfig := clientcmd.NewNonInteractiveClientConfig(apiConfig, k, nil, nil)
 
-			kr.ByName[k] = kcc
+		// The config file includes a default namespace too for the context.
+		ns, _, _ := clientcmdClientConfig.Namespace()
 
-			if kr.Default == nil && k == apiConfig.CurrentContext {
-				kr.Default = kcc
+		// restConfig is what the main library is using.
+		restConfig, err := clientcmdClientConfig.ClientConfig()
+		if err != nil {
+			logger.Warn("Invalid K8S Cluster", "cfg", configFile, "context", k, "cluster", cc.Cluster, "err", err)
+			if c := p.clusters[k]; c != nil {
+				res = append(res, c)
 			}
+			continue
+		}
+		kcc := &K8SCluster{
+			Name:       k,
+			Namespace:  ns,
+			RestConfig: restConfig,
+			RawConfig:  clientcmdClientConfig,
+			Source:     cc.LocationOfOrigin,
+			Labels:     kubeConfigLabels(&apiConfig, k),
 		}
+		p.sums[k] = sum
+		p.clusters[k] = kcc
+		res = append(res, kcc)
+	}
 
-		return nil
+	for k := range p.clusters {
+		if _, f := apiConfig.Contexts[k]; !f {
+			delete(p.clusters, k)
+			delete(p.sums, k)
+		}
+	}
+	return res, nil
+}
+
+// Preferred returns the current context of the files - empty if not set, in which case
+// the in-cluster config becomes the Default.
+func (p *KubeConfigProvider) Preferred() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+// Watch checks the modification time and size of the files every Interval, and calls fn
+// with the clusters if any file changed.
+func (p *KubeConfigProvider) Watch(ctx context.Context, fn func([]*K8SCluster)) error {
+	interval := p.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	return p.watch(ctx, interval, fn)
+}
+
+func (p *KubeConfigProvider) watch(ctx context.Context, interval time.Duration, fn func([]*K8SCluster)) error {
+	watchFiles(ctx, interval, func() string { return kubeConfigStat(p.path()) }, func() {
+		cl, err := p.Discover(ctx)
+		if err != nil {
+			logger.Warn("KubeConfigReloadFailed", "cfg", p.path(), "err", err)
+			return
+		}
+		fn(cl)
+	})
+	return ctx.Err()
+}
+
+// contextSum returns a string identifying the file, endpoint and credentials of a context,
+// used to detect changes.
+func contextSum(cfg *clientcmdapi.Config, name string) string {
+	ctx := cfg.Contexts[name]
+	b, _ := json.Marshal([]interface{}{ctx.LocationOfOrigin, ctx, cfg.Clusters[ctx.Cluster],
+		cfg.AuthInfos[ctx.AuthInfo]})
+	return string(b)
+}
+
+// WatchKubeConfig runs KubeConfigProvider.Watch for the files loaded by LoadKubeConfig,
+// checking them every interval instead of the provider Interval. New contexts are added,
+// removed contexts are dropped and contexts with changed endpoint or credentials get
+// a new K8SCluster - subscribers are notified of each change.
+//
+// WatchProviders watches the files too - only one of them should be used.
+//
+// Blocks until ctx is done.
+func (kr *K8S) WatchKubeConfig(ctx context.Context, interval time.Duration) {
+	var p *KubeConfigProvider
+	for _, o := range kr.providerList() {
+		if kp, ok := o.(*KubeConfigProvider); ok && p == nil {
+			p = kp
+		}
+	}
+	if p == nil {
+		return
+	}
+	p.watch(ctx, interval, func(cl []*K8SCluster) {
+		kr.syncProvider(p, cl)
+	})
+}
+
+// watchFiles calls fn when the result of stat changes, checking every interval.
+//
+// Blocks until ctx is done.
+func watchFiles(ctx context.Context, interval time.Duration, stat func() string, fn func()) {
+	last := stat()
+	pollProvider(ctx, interval, func() {
+		if st := stat(); st != last {
+			last = st
+			fn()
+		}
+	})
+}
+
+// kubeConfigStat returns the modification time and size of each file in the path list.
+func kubeConfigStat(configFile string) string {
+	res := ""
+	for _, p := range filepath.SplitList(configFile) {
+		if fi, err := os.Stat(p); err == nil {
+			res += fmt.Sprintf("%s %d %d\n", p, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return res
+}
+
+// setRateLimits applies the QPS and Burst settings to a cluster config.
+func (kr *K8S) setRateLimits(restConfig *rest.Config) {
+	// Can set restCfg.RateLimiter to replace defaults
+	if kr.QPS > 0 {
+		restConfig.QPS = kr.QPS // default is 5
+	}
+	if kr.Burst > 0 {
+		restConfig.Burst = kr.Burst // default 10
+	}
 }
@@ -0,0 +1,138 @@
+package mk8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DirProvider is a ClusterProvider loading one kubeconfig file per cluster from a directory -
+// for example a mounted Secret with a key per cluster. The cluster is named after the file,
+// without extension, and uses the current context of the file.
+//
+// Files starting with '.' are ignored. If two files have the same name without extension,
+// like a.yaml and a.conf, the first in name order is used and the other is ignored. Like for
+// all providers, a file named after a cluster loaded by an earlier provider is ignored.
+type DirProvider struct {
+	Dir string
+
+	// Interval is used by Watch to check the directory for changes. Defaults to 10s.
+	Interval time.Duration
+
+	mu    sync.Mutex
+	files map[string]*dirFile
+}
+
+type dirFile struct {
+	stat    string
+	cluster *K8SCluster
+}
+
+func (p *DirProvider) Name() string {
+	return "dir:" + p.Dir
+}
+
+// Discover loads the files in the directory, reloading only the files with a different
+// modification time or size. A missing directory has no clusters.
+func (p *DirProvider) Discover(ctx context.Context) ([]*K8SCluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries, err := os.ReadDir(p.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*dirFile{}
+	names := map[string]string{}
+	res := []*K8SCluster{}
+	for _, e := range entries {
+		fn := filepath.Join(p.Dir, e.Name())
+		// Follows symlinks - Secret volumes link the keys to the current version.
+		fi, err := os.Stat(fn)
+		if strings.HasPrefix(e.Name(), ".") || err != nil || fi.IsDir() {
+			continue
+		}
+		stat := fmt.Sprintf("%d %d", fi.ModTime().UnixNano(), fi.Size())
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if prev, f := names[name]; f {
+			logger.Warn("DuplicateClusterName", "cluster", name, "cfg", fn, "used", prev)
+			continue
+		}
+		names[name] = fn
+
+		df := p.files[e.Name()]
+		if df == nil || df.stat != stat {
+			kc, err := loadKubeConfigFile(name, fn)
+			if err != nil {
+				logger.Warn("Invalid K8S Cluster", "cfg", fn, "err", err)
+				if df == nil {
+					continue
+				}
+			} else {
+				df = &dirFile{stat: stat, cluster: kc}
+			}
+		}
+		files[e.Name()] = df
+		res = append(res, df.cluster)
+	}
+	p.files = files
+	return res, nil
+}
+
+// Watch checks the modification time and size of the files every Interval, and calls fn
+// with the clusters if any file changed.
+func (p *DirProvider) Watch(ctx context.Context, fn func([]*K8SCluster)) error {
+	interval := p.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	watchFiles(ctx, interval, p.stat, func() {
+		cl, err := p.Discover(ctx)
+		if err != nil {
+			logger.Warn("KubeConfigReloadFailed", "cfg", p.Dir, "err", err)
+			return
+		}
+		fn(cl)
+	})
+	return ctx.Err()
+}
+
+func (p *DirProvider) stat() string {
+	entries, _ := os.ReadDir(p.Dir)
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		files = append(files, filepath.Join(p.Dir, e.Name()))
+	}
+	return kubeConfigStat(strings.Join(files, string(filepath.ListSeparator)))
+}
+
+// loadKubeConfigFile returns a cluster for the current context of a kubeconfig file.
+func loadKubeConfigFile(name, fn string) (*K8SCluster, error) {
+	cfg, err := clientcmd.LoadFromFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*cfg, cfg.CurrentContext, nil, nil)
+	ns, _, _ := clientConfig.Namespace()
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &K8SCluster{
+		Name:       name,
+		Namespace:  ns,
+		RestConfig: restConfig,
+		RawConfig:  clientConfig,
+		Source:     fn,
+		Labels:     kubeConfigLabels(cfg, cfg.CurrentContext),
+	}, nil
+}
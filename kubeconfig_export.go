@@ -0,0 +1,117 @@
+package mk8s
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// AuthExporter returns the kubeconfig credentials for a cluster using a programmatic
+// auth provider plugin - kubectl doesn't have the plugin, so the exported config
+// must use an exec plugin or a token.
+type AuthExporter func(ctx context.Context, c *K8SCluster) (*clientcmdapi.AuthInfo, error)
+
+var (
+	authExportersMu sync.Mutex
+	authExporters   = map[string]AuthExporter{}
+)
+
+// RegisterAuthExporter registers the function used to export clusters using the
+// named auth provider - typically registered together with rest.RegisterAuthProviderPlugin.
+func RegisterAuthExporter(name string, fn AuthExporter) {
+	authExportersMu.Lock()
+	authExporters[name] = fn
+	authExportersMu.Unlock()
+}
+
+// KubeConfig returns a kubeconfig with one context for each cluster in the set, using the
+// cluster name for the context, cluster and user. The current context is the Default cluster.
+//
+// Clusters that fail to export are skipped.
+func (kr *K8S) KubeConfig(ctx context.Context) *clientcmdapi.Config {
+	cfg := clientcmdapi.NewConfig()
+	for _, c := range kr.Clusters() {
+		cl, ai, err := c.KubeConfig(ctx)
+		if err != nil {
+			logger.Warn("KubeConfigExportFailed", "cluster", c.Name, "err", err)
+			continue
+		}
+		cfg.Clusters[c.Name] = cl
+		cfg.AuthInfos[c.Name] = ai
+		cfg.Contexts[c.Name] = &clientcmdapi.Context{Cluster: c.Name, AuthInfo: c.Name,
+			Namespace: c.Namespace}
+		if len(c.Labels) > 0 {
+			cfg.Contexts[c.Name].Extensions = labelsExtension(c.Labels)
+		}
+	}
+	if def := kr.DefaultCluster(); def != nil && cfg.Contexts[def.Name] != nil {
+		cfg.CurrentContext = def.Name
+	}
+	return cfg
+}
+
+// SaveKubeConfig writes the cluster set to a kubeconfig file, for use with kubectl.
+func (kr *K8S) SaveKubeConfig(ctx context.Context, file string) error {
+	return clientcmd.WriteToFile(*kr.KubeConfig(ctx), file)
+}
+
+// KubeConfig returns the kubeconfig cluster and user for the cluster, based on RestConfig.
+func (kc *K8SCluster) KubeConfig(ctx context.Context) (*clientcmdapi.Cluster, *clientcmdapi.AuthInfo, error) {
+	rc := kc.RestConfig
+
+	cl := clientcmdapi.NewCluster()
+	cl.Server = rc.Host
+	cl.CertificateAuthority = rc.CAFile
+	cl.CertificateAuthorityData = rc.CAData
+	cl.InsecureSkipTLSVerify = rc.Insecure
+	cl.TLSServerName = rc.ServerName
+
+	ai := clientcmdapi.NewAuthInfo()
+	if rc.AuthProvider != nil {
+		authExportersMu.Lock()
+		fn := authExporters[rc.AuthProvider.Name]
+		authExportersMu.Unlock()
+		if fn != nil {
+			exp, err := fn(ctx, kc)
+			if err != nil {
+				return nil, nil, err
+			}
+			ai = exp
+		} else {
+			ai.AuthProvider = rc.AuthProvider
+		}
+	}
+	if rc.ExecProvider != nil {
+		ai.Exec = execConfig(rc.ExecProvider)
+	}
+	if rc.BearerTokenFile != "" {
+		ai.TokenFile = rc.BearerTokenFile
+	} else if rc.BearerToken != "" {
+		ai.Token = rc.BearerToken
+	}
+	ai.ClientCertificate = rc.CertFile
+	ai.ClientCertificateData = rc.CertData
+	ai.ClientKey = rc.KeyFile
+	ai.ClientKeyData = rc.KeyData
+	ai.Username = rc.Username
+	ai.Password = rc.Password
+	ai.Impersonate = rc.Impersonate.UserName
+	ai.ImpersonateUID = rc.Impersonate.UID
+	ai.ImpersonateGroups = rc.Impersonate.Groups
+	ai.ImpersonateUserExtra = rc.Impersonate.Extra
+
+	return cl, ai, nil
+}
+
+// execConfig returns a copy of the exec config without the cluster info, which is
+// not saved in kubeconfig.
+func execConfig(e *clientcmdapi.ExecConfig) *clientcmdapi.ExecConfig {
+	res := *e
+	res.Config = nil
+	if res.InteractiveMode == "" {
+		res.InteractiveMode = clientcmdapi.IfAvailableExecInteractiveMode
+	}
+	return &res
+}
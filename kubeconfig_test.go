@@ -0,0 +1,151 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// testKubeconfigContexts returns a kubeconfig with one context per name, using
+// https://NAME.example.com as server.
+func testKubeconfigContexts(current string, names ...string) string {
+	b := &strings.Builder{}
+	b.WriteString("apiVersion: v1\nkind: Config\ncurrent-context: " + current + "\nclusters:\n")
+	for _, n := range names {
+		b.WriteString("- name: " + n + "\n  cluster:\n    server: https://" + n + ".example.com\n")
+	}
+	b.WriteString("contexts:\n")
+	for _, n := range names {
+		b.WriteString("- name: " + n + "\n  context:\n    cluster: " + n + "\n    user: u\n")
+	}
+	b.WriteString("users:\n- name: u\n  user:\n    token: t\n")
+	return b.String()
+}
+
+func TestWatchKubeConfig(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	kcf := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(kcf, []byte(testKubeconfigContexts("a", "a", "b")), 0600)
+
+	k := &K8S{}
+	err := k.LoadKubeConfig(kcf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Default == nil || k.Default.Name != "a" || len(k.Clusters()) != 2 {
+		t.Fatal("Unexpected clusters", k.Clusters())
+	}
+
+	events := make(chan ClusterEvent, 10)
+	k.Subscribe(func(ev ClusterEvent) {
+		events <- ev
+	})
+	go k.WatchKubeConfig(ctx, 10*time.Millisecond)
+
+	// b removed, c added, a unchanged.
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(kcf, []byte(testKubeconfigContexts("a", "a", "c")), 0600)
+
+	got := map[string]ClusterEventType{}
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got[ev.Cluster.Name] = ev.Type
+		case <-ctx.Done():
+			t.Fatal("Timeout", got)
+		}
+	}
+	if got["b"] != ClusterRemoved || got["c"] != ClusterAdded {
+		t.Error("Unexpected events", got)
+	}
+
+	// Changed endpoint for the default cluster.
+	os.WriteFile(kcf, []byte(strings.ReplaceAll(testKubeconfigContexts("a", "a", "c"),
+		"a.example.com", "a2.example.com")), 0600)
+	select {
+	case ev := <-events:
+		if ev.Type != ClusterChanged || ev.Cluster.Name != "a" {
+			t.Error("Unexpected event", ev)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timeout")
+	}
+	if k.Default.RestConfig.Host != "https://a2.example.com" {
+		t.Error("Default not updated", k.Default.RestConfig.Host)
+	}
+}
+
+func TestLoadKubeConfigMerge(t *testing.T) {
+	dir := t.TempDir()
+	f1 := filepath.Join(dir, "fleet1")
+	f2 := filepath.Join(dir, "fleet2")
+	os.WriteFile(f1, []byte(testKubeconfigContexts("a", "a", "b")), 0600)
+	os.WriteFile(f2, []byte(strings.ReplaceAll(testKubeconfigContexts("c", "a", "c"),
+		"a.example.com", "a2.example.com")), 0600)
+
+	k := &K8S{}
+	err := k.LoadKubeConfig(strings.Join([]string{f1, filepath.Join(dir, "missing"), f2},
+		string(filepath.ListSeparator)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.Clusters()) != 3 || k.Default == nil || k.Default.Name != "a" {
+		t.Fatal("Unexpected clusters", k.Clusters(), k.Default)
+	}
+	if a := k.Cluster("a"); a.Source != f1 || a.RestConfig.Host != "https://a.example.com" {
+		t.Error("First file should win", a.Source, a.RestConfig.Host)
+	}
+	if c := k.Cluster("c"); c.Source != f2 {
+		t.Error("Unexpected source", c.Source)
+	}
+}
+
+func TestSaveKubeConfig(t *testing.T) {
+	ctx := context.Background()
+	RegisterAuthExporter("test-plugin", func(ctx context.Context, c *K8SCluster) (*clientcmdapi.AuthInfo, error) {
+		ai := clientcmdapi.NewAuthInfo()
+		ai.Token = "plugin-" + c.Name
+		return ai, nil
+	})
+
+	k := &K8S{}
+	k.AddCluster(&K8SCluster{Name: "a", Namespace: "ns1",
+		RestConfig: &rest.Config{Host: "https://a.example.com", BearerToken: "t1"}}, true)
+	k.AddCluster(&K8SCluster{Name: "b",
+		RestConfig: &rest.Config{Host: "https://b.example.com",
+			AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "test-plugin"}}}, true)
+	k.Default = k.Cluster("b")
+
+	kcf := filepath.Join(t.TempDir(), "config")
+	err := k.SaveKubeConfig(ctx, kcf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2 := &K8S{}
+	err = k2.LoadKubeConfig(kcf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := k2.Cluster("a"), k2.Cluster("b")
+	if a == nil || b == nil || k2.Default != b {
+		t.Fatal("Unexpected clusters", k2.Clusters())
+	}
+	if a.Namespace != "ns1" || a.RestConfig.BearerToken != "t1" || b.RestConfig.BearerToken != "plugin-b" {
+		t.Error("Unexpected config", a, b.RestConfig)
+	}
+
+	js, _ := json.Marshal(a)
+	if string(js) != `{"Name":"a","Namespace":"ns1","KSA":"","Source":"`+kcf+`"}` {
+		t.Error("Unexpected json", string(js))
+	}
+}
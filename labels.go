@@ -0,0 +1,161 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Well known cluster labels. Labels are set by the discovery sources, and derived from the
+// gke_PROJECT_LOCATION_NAME and connectgateway_PROJECT_NAME context names if missing.
+const (
+	LabelProject = "project"
+
+	// LabelLocation is the GKE location - a region or zone.
+	LabelLocation = "location"
+	LabelRegion   = "region"
+	LabelZone     = "zone"
+
+	// LabelName is the short name of the cluster, without project and location.
+	LabelName = "name"
+)
+
+// LabelsExtension is the name of the kubeconfig cluster or context extension holding the
+// cluster labels - the context labels are merged over the cluster labels.
+//
+//	contexts:
+//	- name: prod
+//	  context:
+//	    extensions:
+//	    - name: mk8s/labels
+//	      extension:
+//	        env: prod
+const LabelsExtension = "mk8s/labels"
+
+// ClusterInfoNamespace and ClusterInfoName identify the ConfigMap holding labels in the
+// cluster itself, loaded by LoadClusterInfo. Not the kubeadm cluster-info ConfigMap in
+// kube-public, used for bootstrap.
+const (
+	ClusterInfoNamespace = "kube-system"
+	ClusterInfoName      = "mk8s-cluster-info"
+)
+
+// LabelSet returns all labels of the cluster - the Labels set by discovery, the labels from
+// the mk8s-cluster-info ConfigMap and the labels derived from the name, in this order of priority.
+func (k *K8SCluster) LabelSet() labels.Set {
+	res := labels.Set(nameLabels(k.Name))
+	if ci := k.clusterInfo.Load(); ci != nil {
+		maps.Copy(res, *ci)
+	}
+	maps.Copy(res, k.Labels)
+	if loc := res[LabelLocation]; loc != "" {
+		if res[LabelRegion] == "" {
+			res[LabelRegion] = loc
+			if isZone(loc) {
+				res[LabelRegion] = loc[:strings.LastIndex(loc, "-")]
+			}
+		}
+		if res[LabelZone] == "" && isZone(loc) {
+			res[LabelZone] = loc
+		}
+	}
+	return res
+}
+
+// LoadClusterInfo reads the labels from the kube-system/mk8s-cluster-info ConfigMap. The labels
+// set by discovery take priority. A missing ConfigMap clears the loaded labels.
+func (k *K8SCluster) LoadClusterInfo(ctx context.Context) error {
+	data, err := k.GetCM(ctx, ClusterInfoNamespace, ClusterInfoName)
+	if err != nil {
+		return err
+	}
+	k.clusterInfo.Store(&data)
+	return nil
+}
+
+// LoadClusterInfo loads the mk8s-cluster-info labels of all clusters, ignoring failures.
+func (kr *K8S) LoadClusterInfo(ctx context.Context) {
+	for _, c := range kr.Clusters() {
+		if err := c.LoadClusterInfo(ctx); err != nil {
+			logger.Info("ClusterInfoFailed", "cluster", c.Name, "err", err)
+		}
+	}
+}
+
+// ClustersMatching returns the clusters with labels matching a selector like
+// "region=us-central1,env=prod", sorted by name.
+func (kr *K8S) ClustersMatching(selector string) ([]*K8SCluster, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	res := []*K8SCluster{}
+	for _, c := range kr.Clusters() {
+		if sel.Matches(c.LabelSet()) {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+// nameLabels parses the GKE and Connect Gateway context names.
+func nameLabels(cf string) map[string]string {
+	parts := strings.Split(cf, "_")
+	switch {
+	case parts[0] == "gke" && len(parts) >= 4:
+		return map[string]string{LabelProject: parts[1], LabelLocation: parts[2],
+			LabelName: strings.Join(parts[3:], "_")}
+	case parts[0] == "connectgateway" && len(parts) >= 3:
+		// connectgateway_PROJECT_NAME or connectgateway_PROJECT_global_NAME
+		// TODO: use registration names that include the location !
+		return map[string]string{LabelProject: parts[1], LabelName: parts[len(parts)-1]}
+	}
+	return map[string]string{}
+}
+
+// isZone returns true for locations like us-central1-c.
+func isZone(loc string) bool {
+	parts := strings.Split(loc, "-")
+	return len(parts) == 3 && len(parts[2]) == 1
+}
+
+// kubeConfigLabels returns the labels from the LabelsExtension of a context and its cluster.
+func kubeConfigLabels(cfg *clientcmdapi.Config, name string) map[string]string {
+	ctx := cfg.Contexts[name]
+	if ctx == nil {
+		return nil
+	}
+	res := map[string]string{}
+	if cl := cfg.Clusters[ctx.Cluster]; cl != nil {
+		extensionLabels(cl.Extensions[LabelsExtension], res)
+	}
+	extensionLabels(ctx.Extensions[LabelsExtension], res)
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+func extensionLabels(ext runtime.Object, res map[string]string) {
+	u, ok := ext.(*runtime.Unknown)
+	if !ok {
+		return
+	}
+	l := map[string]string{}
+	if err := json.Unmarshal(u.Raw, &l); err != nil {
+		logger.Warn("InvalidLabelsExtension", "err", err)
+		return
+	}
+	maps.Copy(res, l)
+}
+
+// labelsExtension returns the extension used to save the labels in a kubeconfig.
+func labelsExtension(l map[string]string) map[string]runtime.Object {
+	raw, _ := json.Marshal(l)
+	return map[string]runtime.Object{LabelsExtension: &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON}}
+}
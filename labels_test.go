@@ -0,0 +1,121 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClusterLabels(t *testing.T) {
+	ctx := context.Background()
+
+	// Short names used to panic.
+	for _, n := range []string{"gke_p", "gke_p_l", "connectgateway_p"} {
+		if p, l, name := (&K8SCluster{Name: n}).GcpInfo(); p != "" || l != "" || name != n {
+			t.Error("Unexpected info", n, p, l, name)
+		}
+	}
+
+	gk := &K8SCluster{Name: "gke_p1_us-central1-c_c1", Labels: map[string]string{"env": "prod"}}
+	if p, l, n := gk.GcpInfo(); p != "p1" || l != "us-central1-c" || n != "c1" {
+		t.Error("Unexpected info", p, l, n)
+	}
+	ls := gk.LabelSet()
+	if ls[LabelRegion] != "us-central1" || ls[LabelZone] != "us-central1-c" || ls["env"] != "prod" {
+		t.Error("Unexpected labels", ls)
+	}
+	cg := &K8SCluster{Name: "connectgateway_p1_global_m1", Labels: map[string]string{LabelLocation: "us-east1"}}
+	if p, l, n := cg.GcpInfo(); p != "p1" || l != "us-east1" || n != "m1" {
+		t.Error("Unexpected info", p, l, n)
+	}
+
+	// Labels from the mk8s-cluster-info ConfigMap, the discovery labels take priority.
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/kube-system/configmaps/mk8s-cluster-info" {
+			w.WriteHeader(404)
+			return
+		}
+		cm := testCM("mk8s-cluster-info", 1)
+		cm.Data = map[string]string{"env": "staging", "team": "t1"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cm)
+	})
+	kc.Labels = map[string]string{"env": "prod"}
+	if err := kc.LoadClusterInfo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if kc.Label(ctx, "team") != "t1" || kc.Label(ctx, "env") != "prod" {
+		t.Error("Unexpected labels", kc.LabelSet())
+	}
+
+	k := &K8S{}
+	k.AddCluster(gk, true)
+	k.AddCluster(cg, true)
+	k.AddCluster(kc, true)
+	cl, err := k.ClustersMatching("region=us-central1,env=prod")
+	if err != nil || len(cl) != 1 || cl[0] != gk {
+		t.Error("Unexpected match", err, cl)
+	}
+	cl, err = k.ClustersMatching("env in (prod)")
+	if err != nil || len(cl) != 2 || cl[0] != gk || cl[1] != kc {
+		t.Error("Unexpected match", err, cl)
+	}
+	if _, err := k.ClustersMatching("env in prod"); err == nil {
+		t.Error("Expected invalid selector")
+	}
+}
+
+func TestKubeConfigLabels(t *testing.T) {
+	kcf := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(kcf, []byte(`apiVersion: v1
+kind: Config
+current-context: a
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com
+    extensions:
+    - name: mk8s/labels
+      extension:
+        region: us-west1
+        env: dev
+contexts:
+- name: a
+  context:
+    cluster: a
+    user: u
+    extensions:
+    - name: mk8s/labels
+      extension:
+        env: prod
+users:
+- name: u
+  user:
+    token: t
+`), 0600)
+
+	k := &K8S{}
+	if err := k.LoadKubeConfig(kcf); err != nil {
+		t.Fatal(err)
+	}
+	a := k.Cluster("a")
+	if a == nil || a.Labels["region"] != "us-west1" || a.Labels["env"] != "prod" {
+		t.Fatal("Unexpected labels", a)
+	}
+
+	// Saved as a context extension.
+	kcf2 := filepath.Join(t.TempDir(), "config")
+	if err := k.SaveKubeConfig(context.Background(), kcf2); err != nil {
+		t.Fatal(err)
+	}
+	k2 := &K8S{}
+	if err := k2.LoadKubeConfig(kcf2); err != nil {
+		t.Fatal(err)
+	}
+	if a2 := k2.Cluster("a"); a2 == nil || len(a2.Labels) != 2 || a2.Labels["env"] != "prod" {
+		t.Error("Unexpected saved labels", a2)
+	}
+}
@@ -0,0 +1,236 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// The K8S client logs using klog - -v=7 lists request headers, -v=9 the full request and
+// response bodies. This library logs using slog, with a separate level.
+//
+// Both can be changed at runtime - with SetK8SLogging, the LogHandler HTTP endpoint or
+// a key in a watched ConfigMap.
+
+// LogLevel is the minimum slog level for the logs of this library. Defaults to Info.
+var LogLevel = &slog.LevelVar{}
+
+// logger is used for all logs of the library. It uses the slog.Default() handler, after
+// checking LogLevel.
+var logger = slog.New(&levelHandler{})
+
+// LogSettings are the klog and slog settings.
+type LogSettings struct {
+	// V is the klog verbosity.
+	V string `json:"v"`
+
+	// VModule is the klog per-file verbosity, like "round_trippers=9".
+	VModule string `json:"vmodule"`
+
+	// Level is the slog level for this library - DEBUG, INFO, WARN or ERROR.
+	Level string `json:"level"`
+}
+
+var (
+	klogFlags = sync.OnceValue(func() *flag.FlagSet {
+		fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+		klog.InitFlags(fs)
+		fs.Func("level", "slog level for the mk8s logs", func(s string) error {
+			return LogLevel.UnmarshalText([]byte(s))
+		})
+		return fs
+	})
+
+	logMu sync.Mutex
+
+	// Cancels a pending revert of temporary settings.
+	logRevert *time.Timer
+)
+
+// SetK8SLogging sets klog flags from a string (to avoid messing with the CLI of
+// the app), for example "-v=9 -vmodule=round_trippers=9". The slog level of the library
+// can be set with "-level=debug".
+//
+// Can be called at any time - the settings are changed dynamically.
+func SetK8SLogging(flags string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	cancelLogRevert()
+	err := klogFlags().Parse(strings.Fields(flags))
+	if err != nil {
+		logger.Warn("InvalidLogFlags", "flags", flags, "err", err)
+	}
+}
+
+// GetLogSettings returns the current klog and slog settings.
+func GetLogSettings() LogSettings {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return getLogSettings()
+}
+
+func getLogSettings() LogSettings {
+	fs := klogFlags()
+	return LogSettings{V: fs.Lookup("v").Value.String(), VModule: fs.Lookup("vmodule").Value.String(),
+		Level: LogLevel.Level().String()}
+}
+
+// SetLogSettings applies the non-empty fields in s. If d is not zero, the previous settings
+// are restored after d - useful for expensive settings like -v=9.
+func SetLogSettings(s LogSettings, d time.Duration) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	prev := getLogSettings()
+	err := setLogSettings(s, false)
+	if err != nil {
+		setLogSettings(prev, true)
+		return err
+	}
+
+	cancelLogRevert()
+	if d > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			logMu.Lock()
+			defer logMu.Unlock()
+			if logRevert == t {
+				setLogSettings(prev, true)
+				logRevert = nil
+				logger.Info("LogSettingsRestored", "v", prev.V, "vmodule", prev.VModule, "level", prev.Level)
+			}
+		})
+		logRevert = t
+	}
+	return nil
+}
+
+// restoreLogSettings sets all the fields of s, cancelling a pending revert.
+func restoreLogSettings(s LogSettings) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	cancelLogRevert()
+	return setLogSettings(s, true)
+}
+
+// setLogSettings applies the non-empty fields in s. With all, the empty fields are applied
+// too - restoring settings from getLogSettings must clear a vmodule set since.
+func setLogSettings(s LogSettings, all bool) error {
+	fs := klogFlags()
+	if all || s.V != "" {
+		if err := fs.Set("v", s.V); err != nil {
+			return err
+		}
+	}
+	if all || s.VModule != "" {
+		if err := fs.Set("vmodule", s.VModule); err != nil {
+			return err
+		}
+	}
+	if all || s.Level != "" {
+		if err := LogLevel.UnmarshalText([]byte(s.Level)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelLogRevert keeps the current settings. Called with logMu held.
+func cancelLogRevert() {
+	if logRevert != nil {
+		logRevert.Stop()
+		logRevert = nil
+	}
+}
+
+// LogHandler returns the log settings on GET, and changes them on POST using the
+// v, vmodule and level query parameters. If duration is set (like "5m") the settings
+// are restored after the duration.
+//
+// The handler doesn't authenticate the caller - it must only be registered on a debug
+// port, or behind a handler checking the caller with Authorize. High verbosity logs
+// request and response bodies, including tokens and secrets.
+func LogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		q := r.URL.Query()
+		var d time.Duration
+		if ds := q.Get("duration"); ds != "" {
+			var err error
+			d, err = time.ParseDuration(ds)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		err := SetLogSettings(LogSettings{V: q.Get("v"), VModule: q.Get("vmodule"), Level: q.Get("level")}, d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetLogSettings())
+}
+
+// WatchLogging watches a key in a ConfigMap, and calls SetK8SLogging with the value when
+// it changes. If the key is removed, the settings from before the watch are restored.
+//
+// Blocks until ctx is done.
+func (kc *K8SCluster) WatchLogging(ctx context.Context, ns, name, key string) error {
+	initial := GetLogSettings()
+
+	last := ""
+	return kc.WatchConfigMaps(ctx, ns, ConfigWatchOptions{Name: name}, func(evs []*DataEvent[string]) {
+		for _, ev := range evs {
+			v := ev.Data[key]
+			if v == last {
+				continue
+			}
+			last = v
+			if v == "" {
+				restoreLogSettings(initial)
+			} else {
+				SetK8SLogging(v)
+			}
+			logger.Info("LogSettingsChanged", "cm", ns+"/"+name, "flags", v)
+		}
+	})
+}
+
+// levelHandler filters the records using LogLevel, and sends them to h or the current
+// default handler.
+type levelHandler struct {
+	h slog.Handler
+}
+
+func (h *levelHandler) handler() slog.Handler {
+	if h.h != nil {
+		return h.h
+	}
+	return slog.Default().Handler()
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= LogLevel.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h: h.handler().WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h: h.handler().WithGroup(name)}
+}
@@ -0,0 +1,50 @@
+package mk8s
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLogHandler(t *testing.T) {
+	SetK8SLogging("-v=2 -level=warn")
+	defer SetK8SLogging("-v=0 -level=info")
+	if s := GetLogSettings(); s.V != "2" || s.Level != "WARN" {
+		t.Fatal("Unexpected settings", s)
+	}
+
+	w := httptest.NewRecorder()
+	LogHandler(w, httptest.NewRequest("POST", "/debug/logging?v=9&level=debug&duration=50ms", nil))
+	s := &LogSettings{}
+	json.Unmarshal(w.Body.Bytes(), s)
+	if w.Code != 200 || s.V != "9" || s.Level != "DEBUG" {
+		t.Fatal("Unexpected response", w.Code, w.Body.String())
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if s := GetLogSettings(); s.V != "2" || s.Level != "WARN" {
+		t.Error("Settings not restored", s)
+	}
+
+	// An empty vmodule is restored too.
+	if s := GetLogSettings(); s.VModule != "" {
+		t.Fatal("Unexpected vmodule", s)
+	}
+	if err := SetLogSettings(LogSettings{VModule: "round_trippers=9"}, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if s := GetLogSettings(); s.VModule != "round_trippers=9" {
+		t.Fatal("Unexpected vmodule", s)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if s := GetLogSettings(); s.VModule != "" || s.V != "2" {
+		t.Error("vmodule not restored", s)
+	}
+
+	w = httptest.NewRecorder()
+	LogHandler(w, httptest.NewRequest("POST", "/debug/logging?level=bad", nil))
+	if w.Code != 400 {
+		t.Error("Expected error", w.Code)
+	}
+}
@@ -0,0 +1,203 @@
+package mk8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ClusterWatchEvent is a watch event from one of the clusters in a K8S set.
+type ClusterWatchEvent struct {
+	// Cluster is the name of the source cluster - the key in K8S.ByName.
+	Cluster string
+
+	*RawEvent
+}
+
+// WatchOptions selects the objects and clusters for WatchAll.
+type WatchOptions struct {
+	// Namespace to watch - empty for all namespaces.
+	Namespace string
+
+	LabelSelector string
+	FieldSelector string
+
+	// Clusters is the list of cluster names to watch. If empty, all clusters in the set,
+	// including the clusters added later.
+	Clusters []string
+
+	// Backoff is used when a cluster watch fails. Defaults to 1s, doubling up to 5 min.
+	Backoff *wait.Backoff
+}
+
+// WatchAll watches a resource in all clusters (or the selected subset), and merges the
+// events in a single channel. The channel is closed when ctx is done.
+//
+// Each cluster sends its objects as ADDED, followed by a BOOKMARK where IsInitialEventsEnd
+// is true, followed by changes - like ListWatch.
+//
+// If a cluster fails or disconnects it is retried with backoff, without affecting the
+// others. The watch resumes from the last revision if possible - if the revision is too
+// old, the objects are sent again as ADDED, followed by a new initial events BOOKMARK.
+//
+// Clusters added to the set later are watched too, and the watches of removed clusters
+// are stopped - no DELETED events are sent for their objects. A cluster replaced with a
+// new config is watched again from the start.
+func (kr *K8S) WatchAll(ctx context.Context, gvr schema.GroupVersionResource, opts WatchOptions) <-chan *ClusterWatchEvent {
+	ch := make(chan *ClusterWatchEvent, 128)
+	mw := &multiWatch{ctx: ctx, ch: ch, gvr: gvr, opts: opts, watches: map[string]*clusterWatch{}}
+	if len(opts.Clusters) > 0 {
+		mw.names = map[string]bool{}
+		for _, n := range opts.Clusters {
+			mw.names[n] = true
+		}
+	}
+
+	// Subscribed first, to not miss clusters added while starting.
+	unsubscribe := kr.subscribe(func(ev ClusterEvent) {
+		switch ev.Type {
+		case ClusterAdded, ClusterChanged:
+			mw.start(ev.Cluster)
+		case ClusterRemoved:
+			mw.stop(ev.Cluster)
+		}
+	})
+	for _, c := range kr.Clusters() {
+		mw.start(c)
+	}
+	for _, n := range opts.Clusters {
+		if kr.Cluster(n) == nil {
+			logger.Warn("WatchAllMissingCluster", "cluster", n)
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+		mw.mu.Lock()
+		mw.closed = true
+		mw.mu.Unlock()
+		mw.wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// multiWatch tracks the cluster watches of WatchAll.
+type multiWatch struct {
+	ctx  context.Context
+	ch   chan *ClusterWatchEvent
+	gvr  schema.GroupVersionResource
+	opts WatchOptions
+
+	// names are the watched clusters, nil for all.
+	names map[string]bool
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closed  bool
+	watches map[string]*clusterWatch
+}
+
+type clusterWatch struct {
+	c      *K8SCluster
+	cancel context.CancelFunc
+}
+
+// start watches the cluster, replacing the watch of a previous cluster with the same name.
+func (mw *multiWatch) start(c *K8SCluster) {
+	if mw.names != nil && !mw.names[c.Name] {
+		return
+	}
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.closed {
+		return
+	}
+	if cw := mw.watches[c.Name]; cw != nil {
+		if cw.c == c {
+			return
+		}
+		cw.cancel()
+	}
+	ctx, cancel := context.WithCancel(mw.ctx)
+	mw.watches[c.Name] = &clusterWatch{c: c, cancel: cancel}
+
+	mw.wg.Add(1)
+	go func() {
+		defer mw.wg.Done()
+		retryListWatch(ctx, c, mw.gvr, mw.opts, func(ev *RawEvent) error {
+			select {
+			case mw.ch <- &ClusterWatchEvent{Cluster: c.Name, RawEvent: ev}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+}
+
+// stop cancels the watch of a removed cluster.
+func (mw *multiWatch) stop(c *K8SCluster) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if cw := mw.watches[c.Name]; cw != nil && cw.c == c {
+		cw.cancel()
+		delete(mw.watches, c.Name)
+	}
+}
+
+// retryListWatch calls ListWatch until ctx is done, retrying with backoff on errors.
+func retryListWatch(ctx context.Context, c *K8SCluster, gvr schema.GroupVersionResource, opts WatchOptions, fn func(*RawEvent) error) {
+	backoff := DefaultWatchBackoff
+	if opts.Backoff != nil {
+		backoff = *opts.Backoff
+	}
+	b := backoff
+
+	lopts := metav1.ListOptions{LabelSelector: opts.LabelSelector, FieldSelector: opts.FieldSelector}
+	for {
+		// Only resume from revisions seen after the initial objects - a partial list must
+		// be restarted.
+		synced := lopts.ResourceVersion != ""
+		err := c.ListWatch(ctx, gvr, opts.Namespace, lopts, func(ev *RawEvent) error {
+			err := fn(ev)
+			if err != nil {
+				return err
+			}
+			// Got an event - the cluster is healthy.
+			b = backoff
+			if !synced {
+				synced = ev.IsInitialEventsEnd()
+				if !synced {
+					return nil
+				}
+			}
+			pom, err := ev.Meta()
+			if err != nil {
+				return err
+			}
+			lopts.ResourceVersion = pom.ResourceVersion
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if k8serrors.IsResourceExpired(err) || k8serrors.IsGone(err) {
+			lopts.ResourceVersion = ""
+		}
+
+		d := b.Step()
+		logger.Info("WatchAllRetry", "cluster", c.Name, "resource", gvr.Resource, "err", err, "delay", d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestWatchAll(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	// Server without streaming lists - c2 fails the first list.
+	server := func(name string, failures int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			switch {
+			case q.Get("sendInitialEvents") == "true":
+				w.WriteHeader(400)
+			case q.Get("watch") != "true":
+				if failures > 0 {
+					failures--
+					w.WriteHeader(500)
+					return
+				}
+				json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+					Items: []v1.ConfigMap{testCM(name, 5)}})
+			default:
+				<-r.Context().Done()
+			}
+		}
+	}
+
+	k := &K8S{ByName: map[string]*K8SCluster{}}
+	for _, n := range []string{"c1", "c2", "c3"} {
+		kc := httpCluster(t, server(n, map[string]int{"c2": 2}[n]))
+		kc.Name = n
+		k.ByName[n] = kc
+	}
+
+	ch := k.WatchAll(ctx, cmGVR, WatchOptions{Namespace: "default", Clusters: []string{"c1", "c2"},
+		Backoff: &wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1, Steps: 10}})
+
+	added := map[string]string{}
+	synced := 0
+	for ev := range ch {
+		if ev.IsInitialEventsEnd() {
+			synced++
+			if synced == 2 {
+				cf()
+			}
+			continue
+		}
+		m, _ := ev.Meta()
+		added[ev.Cluster] = m.Name
+	}
+	if len(added) != 2 || added["c1"] != "c1" || added["c2"] != "c2" {
+		t.Error("Unexpected events", added)
+	}
+}
+
+func TestWatchAllDynamic(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	stopped := make(chan string, 10)
+	server := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("sendInitialEvents") == "true" {
+				w.WriteHeader(400)
+				return
+			}
+			if q.Get("watch") != "true" {
+				json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+					Items: []v1.ConfigMap{testCM(name, 5)}})
+				return
+			}
+			w.WriteHeader(200)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			stopped <- name
+		}
+	}
+	cluster := func(n string) *K8SCluster {
+		kc := httpCluster(t, server(n))
+		kc.Name = n
+		return kc
+	}
+
+	k := &K8S{}
+	k.AddCluster(cluster("c1"), true)
+	ch := k.WatchAll(ctx, cmGVR, WatchOptions{Namespace: "default"})
+	synced := func(want string) {
+		t.Helper()
+		for ev := range ch {
+			if ev.IsInitialEventsEnd() {
+				if ev.Cluster != want {
+					t.Fatal("Unexpected cluster", ev.Cluster, want)
+				}
+				return
+			}
+		}
+		t.Fatal("Channel closed")
+	}
+	synced("c1")
+
+	// Added later.
+	k.AddCluster(cluster("c2"), true)
+	synced("c2")
+
+	k.RemoveCluster("c1")
+	select {
+	case n := <-stopped:
+		if n != "c1" {
+			t.Error("Unexpected watch stopped", n)
+		}
+	case <-ctx.Done():
+		t.Fatal("Watch of removed cluster not stopped")
+	}
+
+	cf()
+	for range ch {
+	}
+}
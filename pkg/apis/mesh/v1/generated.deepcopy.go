@@ -1,83 +0,0 @@
-//go:build !ignore_autogenerated
-// +build !ignore_autogenerated
-
-// Code generated by deepcopy-gen. DO NOT EDIT.
-
-package v1
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Ptr) DeepCopyInto(out *Ptr) {
-	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
-	return
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Ptr.
-func (in *Ptr) DeepCopy() *Ptr {
-	if in == nil {
-		return nil
-	}
-	out := new(Ptr)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PtrList) DeepCopyInto(out *PtrList) {
-	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]Ptr, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	return
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PtrList.
-func (in *PtrList) DeepCopy() *PtrList {
-	if in == nil {
-		return nil
-	}
-	out := new(PtrList)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PtrSpec) DeepCopyInto(out *PtrSpec) {
-	*out = *in
-	return
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PtrSpec.
-func (in *PtrSpec) DeepCopy() *PtrSpec {
-	if in == nil {
-		return nil
-	}
-	out := new(PtrSpec)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *PtrStatus) DeepCopyInto(out *PtrStatus) {
-	*out = *in
-	return
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PtrStatus.
-func (in *PtrStatus) DeepCopy() *PtrStatus {
-	if in == nil {
-		return nil
-	}
-	out := new(PtrStatus)
-	in.DeepCopyInto(out)
-	return out
-}
@@ -1,10 +1,36 @@
 package v1
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // SyncStatus tracks the sync status for K8S or other revisioned resource.
 //
 // This is an alternative to an informer holding the entire data set in memory and loading
 // all data at startup.
 //
 // A client would load the sync status and list or watch based on last resource that was handled.
+// A full list is only needed if the saved revision is too old (410 Gone) - in which case
+// the Continue token is used to resume a paged list that was interrupted.
 type SyncStatus struct {
+	// Cluster is the name of the cluster - the key in K8S.ByName.
+	Cluster string `json:"cluster,omitempty"`
+
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+
+	// Namespace is empty for all namespaces or cluster-scoped resources.
+	Namespace string `json:"namespace,omitempty"`
+
+	// ResourceVersion of the last object that was handled, or the revision of the
+	// list in progress if Continue is set.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	// Continue is set while a paged list is in progress, and is the token for the next
+	// page.
+	Continue string `json:"continue,omitempty"`
+
+	// LastSync is the time the status was saved.
+	LastSync metav1.Time `json:"lastSync,omitempty"`
 }
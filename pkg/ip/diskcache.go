@@ -0,0 +1,40 @@
+package k8s
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/costinm/mk8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// StartCached watches pods and nodes using a disk cache instead of informers.
+//
+// The K8SData is populated from the files in dir right away - before the cluster is
+// reachable - and updated with the changes since the last run once the watch resumes.
+// Failed watches are restarted with mk8s.DefaultWatchBackoff until ctx is done, resuming
+// from the saved revision.
+func StartCached(ctx context.Context, kd *K8SData, kc *mk8s.K8SCluster, dir string) {
+	dc := &mk8s.DiskCache{Dir: dir}
+	for _, r := range []string{"pods", "nodes"} {
+		gvr := schema.GroupVersionResource{Version: "v1", Resource: r}
+		go func() {
+			b := mk8s.DefaultWatchBackoff
+			for {
+				err := dc.Watch(ctx, kc, gvr, "", metav1.ListOptions{}, kd)
+				if ctx.Err() != nil {
+					return
+				}
+				d := b.Step()
+				slog.Warn("K8SCachedWatch", "cluster", kc.Name, "resource", gvr.Resource, "err", err, "delay", d)
+				select {
+				case <-time.After(d):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,241 @@
+package k8stest
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Tokens are ES256 JWTs signed with a key generated for each server, with the same claims
+// as the real service account tokens. The issuer is the server URL.
+
+type tokenClaims struct {
+	Iss string   `json:"iss"`
+	Sub string   `json:"sub"`
+	Aud []string `json:"aud"`
+	Exp int64    `json:"exp"`
+	Iat int64    `json:"iat"`
+	Nbf int64    `json:"nbf"`
+
+	K8S struct {
+		Namespace      string  `json:"namespace"`
+		ServiceAccount *objRef `json:"serviceaccount,omitempty"`
+		Pod            *objRef `json:"pod,omitempty"`
+		Secret         *objRef `json:"secret,omitempty"`
+	} `json:"kubernetes.io"`
+}
+
+type objRef struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+}
+
+var saGVR = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+
+// Token returns a token for a service account, like a TokenRequest. The service account
+// doesn't need to exist. If no audience is set the issuer is used.
+func (s *Server) Token(ns, ksa string, exp time.Duration, aud ...string) string {
+	return s.sign(ns, ksa, "", exp, aud, nil)
+}
+
+func (s *Server) sign(ns, ksa, uid string, exp time.Duration, aud []string, bound *authenticationv1.BoundObjectReference) string {
+	if len(aud) == 0 {
+		aud = []string{s.URL}
+	}
+	now := time.Now()
+	c := &tokenClaims{Iss: s.URL, Sub: "system:serviceaccount:" + ns + ":" + ksa, Aud: aud,
+		Exp: now.Add(exp).Unix(), Iat: now.Unix(), Nbf: now.Unix()}
+	c.K8S.Namespace = ns
+	c.K8S.ServiceAccount = &objRef{Name: ksa, UID: uid}
+	if bound != nil {
+		switch bound.Kind {
+		case "Pod":
+			c.K8S.Pod = &objRef{Name: bound.Name, UID: string(bound.UID)}
+		case "Secret":
+			c.K8S.Secret = &objRef{Name: bound.Name, UID: string(bound.UID)}
+		}
+	}
+
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(rawJSON(map[string]string{"alg": "ES256", "kid": s.kid, "typ": "JWT"})) +
+		"." + enc.EncodeToString(rawJSON(c))
+	h := sha256.Sum256([]byte(signed))
+	r, sv, err := ecdsa.Sign(rand.Reader, s.key, h[:])
+	if err != nil {
+		panic(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	sv.FillBytes(sig[32:])
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+// verify checks the signature and expiry, returning the claims.
+func (s *Server) verify(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid token")
+	}
+	enc := base64.RawURLEncoding
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, errors.New("invalid signature")
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(&s.key.PublicKey, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, errors.New("invalid signature")
+	}
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	c := &tokenClaims{}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > c.Exp {
+		return nil, errors.New("token expired")
+	}
+	return c, nil
+}
+
+func (s *Server) serveDiscovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"jwks_uri":                              s.URL + "/openid/v1/jwks",
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+	})
+}
+
+func (s *Server) serveJWKS(w http.ResponseWriter) {
+	enc := base64.RawURLEncoding
+	pk := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": s.kid, "alg": "ES256", "use": "sig",
+		"x": enc.EncodeToString(pk.X.FillBytes(make([]byte, 32))),
+		"y": enc.EncodeToString(pk.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+// serveTokenRequest issues a token for an existing service account.
+func (s *Server) serveTokenRequest(w http.ResponseWriter, r *http.Request, ns, name string) {
+	tr := &authenticationv1.TokenRequest{}
+	if err := readJSON(r, tr); err != nil {
+		writeStatus(w, err)
+		return
+	}
+	s.mu.Lock()
+	sa := s.resource(saGVR).objects[key(ns, name)]
+	s.mu.Unlock()
+	if sa == nil {
+		writeStatus(w, k8serrors.NewNotFound(saGVR.GroupResource(), name))
+		return
+	}
+
+	exp := int64(3600)
+	if tr.Spec.ExpirationSeconds != nil {
+		exp = *tr.Spec.ExpirationSeconds
+	}
+	if exp < 600 {
+		writeStatus(w, k8serrors.NewBadRequest("may not specify a duration less than 10 minutes"))
+		return
+	}
+	d := time.Duration(exp) * time.Second
+	tr.Status.Token = s.sign(ns, name, sa.meta().str("uid"), d, tr.Spec.Audiences, tr.Spec.BoundObjectRef)
+	tr.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(d))
+	if len(tr.Spec.Audiences) == 0 {
+		tr.Spec.Audiences = []string{s.URL}
+	}
+	tr.Spec.ExpirationSeconds = &exp
+	tr.TypeMeta = metav1.TypeMeta{Kind: "TokenRequest", APIVersion: "authentication.k8s.io/v1"}
+	tr.Namespace, tr.Name = ns, name
+	writeJSON(w, http.StatusCreated, tr)
+}
+
+// serveTokenReview authenticates the tokens issued by the server.
+func (s *Server) serveTokenReview(w http.ResponseWriter, r *http.Request) {
+	tr := &authenticationv1.TokenReview{}
+	if err := readJSON(r, tr); err != nil {
+		writeStatus(w, err)
+		return
+	}
+	tr.TypeMeta = metav1.TypeMeta{Kind: "TokenReview", APIVersion: "authentication.k8s.io/v1"}
+
+	aud := tr.Spec.Audiences
+	if len(aud) == 0 {
+		aud = []string{s.URL}
+	}
+	c, err := s.verify(tr.Spec.Token)
+	if err == nil && !slices.ContainsFunc(aud, func(a string) bool { return slices.Contains(c.Aud, a) }) {
+		err = errors.New("token audiences is invalid for the target audiences")
+	}
+	if err != nil {
+		tr.Status = authenticationv1.TokenReviewStatus{Error: err.Error()}
+		writeJSON(w, http.StatusCreated, tr)
+		return
+	}
+
+	ns := c.K8S.Namespace
+	tr.Status = authenticationv1.TokenReviewStatus{Authenticated: true, Audiences: aud,
+		User: authenticationv1.UserInfo{Username: c.Sub,
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + ns, "system:authenticated"}}}
+	if c.K8S.ServiceAccount != nil {
+		tr.Status.User.UID = c.K8S.ServiceAccount.UID
+	}
+	writeJSON(w, http.StatusCreated, tr)
+}
+
+// serveAccessReview decides subject and self access reviews using the Authorizer. Self
+// reviews are made as the "admin" user, or the impersonated user.
+func (s *Server) serveAccessReview(w http.ResponseWriter, r *http.Request, resource string) {
+	sar := &authorizationv1.SubjectAccessReview{}
+	if err := readJSON(r, sar); err != nil {
+		writeStatus(w, err)
+		return
+	}
+	kind := "SubjectAccessReview"
+	if resource == "selfsubjectaccessreviews" {
+		kind = "SelfSubjectAccessReview"
+		sar.Spec.User = "admin"
+		sar.Spec.Groups = []string{"system:masters", "system:authenticated"}
+		if u := r.Header.Get("Impersonate-User"); u != "" {
+			sar.Spec.User, sar.Spec.UID = u, r.Header.Get("Impersonate-Uid")
+			sar.Spec.Groups = r.Header.Values("Impersonate-Group")
+		}
+	}
+	allowed := s.Authorizer == nil || s.Authorizer(&sar.Spec)
+	sar.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: allowed}
+	if !allowed {
+		sar.Status.Reason = "denied by k8stest Authorizer"
+	}
+	sar.TypeMeta = metav1.TypeMeta{Kind: kind, APIVersion: "authorization.k8s.io/v1"}
+	writeJSON(w, http.StatusCreated, sar)
+}
+
+func readJSON(r *http.Request, obj interface{}) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, obj); err != nil {
+		return k8serrors.NewBadRequest(err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,492 @@
+package k8stest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+)
+
+// Create stores an object - a typed object like *v1.ConfigMap or a map. Returns the
+// stored object, with the server-set metadata.
+func (s *Server) Create(gvr schema.GroupVersionResource, obj interface{}) (map[string]interface{}, error) {
+	o, err := toObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.resource(gvr)
+	if res == nil {
+		return nil, k8serrors.NewNotFound(gvr.GroupResource(), "")
+	}
+	o, err = s.create(res, o.meta().str("namespace"), o)
+	if err != nil {
+		return nil, err
+	}
+	return o.copy(), nil
+}
+
+// MustCreate is like Create, and panics on errors - for test setup.
+func (s *Server) MustCreate(gvr schema.GroupVersionResource, obj interface{}) map[string]interface{} {
+	o, err := s.Create(gvr, obj)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// Update replaces an object. If the object has a resourceVersion it must match.
+func (s *Server) Update(gvr schema.GroupVersionResource, obj interface{}) (map[string]interface{}, error) {
+	o, err := toObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.resource(gvr)
+	if res == nil {
+		return nil, k8serrors.NewNotFound(gvr.GroupResource(), "")
+	}
+	m := o.meta()
+	o, err = s.update(res, m.str("namespace"), m.str("name"), o, "")
+	if err != nil {
+		return nil, err
+	}
+	return o.copy(), nil
+}
+
+// Get returns a stored object.
+func (s *Server) Get(gvr schema.GroupVersionResource, ns, name string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.resource(gvr)
+	if res == nil || res.objects[key(ns, name)] == nil {
+		return nil, k8serrors.NewNotFound(gvr.GroupResource(), name)
+	}
+	return res.objects[key(ns, name)].copy(), nil
+}
+
+// Delete removes an object.
+func (s *Server) Delete(gvr schema.GroupVersionResource, ns, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.resource(gvr)
+	if res == nil {
+		return k8serrors.NewNotFound(gvr.GroupResource(), name)
+	}
+	_, err := s.delete(res, ns, name)
+	return err
+}
+
+// create is called with mu held.
+func (s *Server) create(res *resource, ns string, o object) (object, error) {
+	m := o.meta()
+	if !res.namespaced {
+		ns = ""
+	} else if ns == "" {
+		return nil, k8serrors.NewBadRequest("namespace is required")
+	} else if mns := m.str("namespace"); mns != "" && mns != ns {
+		return nil, k8serrors.NewBadRequest("the namespace of the object does not match the namespace of the request")
+	}
+	name := m.str("name")
+	if name == "" && m.str("generateName") != "" {
+		s.uid++
+		name = fmt.Sprintf("%s%05x", m.str("generateName"), s.uid)
+	}
+	if name == "" {
+		return nil, k8serrors.NewBadRequest("name is required")
+	}
+	if res.objects[key(ns, name)] != nil {
+		return nil, k8serrors.NewAlreadyExists(res.gvr.GroupResource(), name)
+	}
+
+	s.uid++
+	m["name"] = name
+	m["uid"] = fmt.Sprintf("00000000-0000-0000-0000-%012x", s.uid)
+	m["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	m["generation"] = 1
+	if ns != "" {
+		m["namespace"] = ns
+	}
+	s.store(res, ns, name, o, watch.Added)
+	return o, nil
+}
+
+// update replaces the object, keeping the server-set metadata. The status subresource
+// only changes the status, other updates keep the status. Called with mu held.
+func (s *Server) update(res *resource, ns, name string, o object, sub string) (object, error) {
+	if !res.namespaced {
+		ns = ""
+	}
+	cur := res.objects[key(ns, name)]
+	if cur == nil {
+		return nil, k8serrors.NewNotFound(res.gvr.GroupResource(), name)
+	}
+	m, cm := o.meta(), cur.meta()
+	if n := m.str("name"); n != "" && n != name {
+		return nil, k8serrors.NewBadRequest("the name of the object does not match the name of the request")
+	}
+	if rv := m.str("resourceVersion"); rv != "" && rv != cm.str("resourceVersion") {
+		return nil, k8serrors.NewConflict(res.gvr.GroupResource(), name,
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	if sub == "status" {
+		n := cur.copy()
+		n["status"] = o["status"]
+		o = n
+		m = o.meta()
+	} else {
+		o["status"] = cur["status"]
+		if o["status"] == nil {
+			delete(o, "status")
+		}
+		m["generation"] = generation(cm) + 1
+	}
+	for _, k := range []string{"uid", "creationTimestamp", "namespace"} {
+		if cm[k] != nil {
+			m[k] = cm[k]
+		}
+	}
+	m["name"] = name
+	s.store(res, ns, name, o, watch.Modified)
+	return o, nil
+}
+
+func generation(m metadata) int64 {
+	switch g := m["generation"].(type) {
+	case float64:
+		return int64(g)
+	case int:
+		return int64(g)
+	case int64:
+		return g
+	}
+	return 0
+}
+
+// delete is called with mu held.
+func (s *Server) delete(res *resource, ns, name string) (object, error) {
+	if !res.namespaced {
+		ns = ""
+	}
+	cur := res.objects[key(ns, name)]
+	if cur == nil {
+		return nil, k8serrors.NewNotFound(res.gvr.GroupResource(), name)
+	}
+	o := cur.copy()
+	s.store(res, ns, name, o, watch.Deleted)
+	return o, nil
+}
+
+// store sets the revision, saves the object and records the event. Called with mu held.
+func (s *Server) store(res *resource, ns, name string, o object, typ watch.EventType) {
+	s.rv++
+	o.meta()["resourceVersion"] = strconv.FormatInt(s.rv, 10)
+	o["apiVersion"] = res.gvr.GroupVersion().String()
+	o["kind"] = res.kind
+
+	k := key(ns, name)
+	prev := res.objects[k]
+	if typ == watch.Deleted {
+		delete(res.objects, k)
+	} else {
+		res.objects[k] = o
+	}
+	s.events = append(s.events, &event{rv: s.rv, gvr: res.gvr, typ: typ, obj: o, prev: prev})
+	s.notify()
+}
+
+func (s *Server) serveGet(w http.ResponseWriter, res *resource, ns, name string) {
+	s.mu.Lock()
+	o := res.objects[key(ns, name)]
+	s.mu.Unlock()
+	if o == nil {
+		writeStatus(w, k8serrors.NewNotFound(res.gvr.GroupResource(), name))
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) serveCreate(w http.ResponseWriter, r *http.Request, res *resource, ns string) {
+	o, err := readObject(r)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	s.mu.Lock()
+	o, err = s.create(res, ns, o)
+	s.mu.Unlock()
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, o)
+}
+
+func (s *Server) serveUpdate(w http.ResponseWriter, r *http.Request, res *resource, ns, name, sub string) {
+	o, err := readObject(r)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	s.mu.Lock()
+	o, err = s.update(res, ns, name, o, sub)
+	s.mu.Unlock()
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// servePatch applies merge, strategic merge and apply patches - all as JSON merge patches.
+// Apply creates the object if it doesn't exist.
+func (s *Server) servePatch(w http.ResponseWriter, r *http.Request, res *resource, ns, name, sub string) {
+	pt := types.PatchType(r.Header.Get("Content-Type"))
+	if pt != types.MergePatchType && pt != types.StrategicMergePatchType && pt != types.ApplyPatchType {
+		writeStatus(w, k8serrors.NewGenericServerResponse(http.StatusUnsupportedMediaType, "patch",
+			res.gvr.GroupResource(), name, "unsupported patch type "+string(pt), 0, false))
+		return
+	}
+	p, err := readObject(r)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ens := ns
+	if !res.namespaced {
+		ens = ""
+	}
+	cur := res.objects[key(ens, name)]
+	var o object
+	switch {
+	case cur == nil && pt == types.ApplyPatchType:
+		p.meta()["name"] = name
+		o, err = s.create(res, ns, p)
+		if err == nil {
+			writeJSON(w, http.StatusCreated, o)
+			return
+		}
+	case cur == nil:
+		err = k8serrors.NewNotFound(res.gvr.GroupResource(), name)
+	default:
+		merged := mergePatch(map[string]interface{}(cur.copy()), map[string]interface{}(p)).(map[string]interface{})
+		o, err = s.update(res, ns, name, merged, sub)
+	}
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386).
+func mergePatch(cur interface{}, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	cm, ok := cur.(map[string]interface{})
+	if !ok {
+		cm = map[string]interface{}{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(cm, k)
+		} else {
+			cm[k] = mergePatch(cm[k], v)
+		}
+	}
+	return cm
+}
+
+func (s *Server) serveDelete(w http.ResponseWriter, res *resource, ns, name string) {
+	s.mu.Lock()
+	o, err := s.delete(res, ns, name)
+	s.mu.Unlock()
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) serveDeleteCollection(w http.ResponseWriter, r *http.Request, res *resource, ns string) {
+	match, err := selector(r)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	s.mu.Lock()
+	for _, o := range res.objects {
+		m := o.meta()
+		if (ns == "" || m.str("namespace") == ns) && match(o) {
+			s.delete(res, m.str("namespace"), m.str("name"))
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, &metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status: metav1.StatusSuccess})
+}
+
+// continueToken is the decoded continue parameter of paginated lists.
+type continueToken struct {
+	RV    int64  `json:"rv"`
+	Start string `json:"start"`
+}
+
+// serveList returns the current objects sorted by namespace and name. Paginated lists
+// return the current objects after the continue key - not a snapshot.
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request, res *resource, ns string) {
+	q := r.URL.Query()
+	match, err := selector(r)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv := s.rv
+	start := ""
+	if c := q.Get("continue"); c != "" {
+		ct := &continueToken{}
+		b, err := base64.RawURLEncoding.DecodeString(c)
+		if err == nil {
+			err = json.Unmarshal(b, ct)
+		}
+		if err != nil {
+			writeStatus(w, k8serrors.NewBadRequest("invalid continue token"))
+			return
+		}
+		if ct.RV <= s.compacted {
+			writeStatus(w, k8serrors.NewResourceExpired("The provided continue parameter is too old"))
+			return
+		}
+		rv, start = ct.RV, ct.Start
+	}
+
+	keys := make([]string, 0, len(res.objects))
+	for k, o := range res.objects {
+		if k > start && (ns == "" || o.meta().str("namespace") == ns) && match(o) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	lm := metav1.ListMeta{ResourceVersion: strconv.FormatInt(rv, 10)}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		lm.Continue = base64.RawURLEncoding.EncodeToString(rawJSON(&continueToken{RV: rv, Start: keys[limit-1]}))
+	}
+	items := make([]object, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, res.objects[k])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"apiVersion": res.gvr.GroupVersion().String(),
+		"kind":       res.kind + "List",
+		"metadata":   lm,
+		"items":      items,
+	})
+}
+
+// selector returns a filter for the labelSelector and fieldSelector parameters. Only the
+// metadata.name and metadata.namespace fields are supported.
+func selector(r *http.Request) (func(object) bool, error) {
+	q := r.URL.Query()
+	ls, err := labels.Parse(q.Get("labelSelector"))
+	if err != nil {
+		return nil, k8serrors.NewBadRequest(err.Error())
+	}
+	fs, err := fields.ParseSelector(q.Get("fieldSelector"))
+	if err != nil {
+		return nil, k8serrors.NewBadRequest(err.Error())
+	}
+	return func(o object) bool {
+		m := o.meta()
+		l := labels.Set{}
+		if ml, ok := m["labels"].(map[string]interface{}); ok {
+			for k, v := range ml {
+				l[k] = fmt.Sprint(v)
+			}
+		}
+		return ls.Matches(l) && fs.Matches(fields.Set{"metadata.name": m.str("name"),
+			"metadata.namespace": m.str("namespace")})
+	}, nil
+}
+
+func key(ns, name string) string {
+	return ns + "/" + name
+}
+
+// metadata is the metadata of an object.
+type metadata map[string]interface{}
+
+func (m metadata) str(k string) string {
+	s, _ := m[k].(string)
+	return s
+}
+
+// meta returns the metadata of the object, adding it if missing.
+func (o object) meta() metadata {
+	m, ok := o["metadata"].(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+		o["metadata"] = m
+	}
+	return m
+}
+
+// copy returns a deep copy.
+func (o object) copy() object {
+	c := object{}
+	json.Unmarshal(rawJSON(o), &c)
+	return c
+}
+
+// toObject converts a typed object or map to an object, using a JSON round trip.
+func toObject(obj interface{}) (object, error) {
+	o := object{}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return o, json.Unmarshal(b, &o)
+}
+
+// readObject decodes a JSON or YAML request body.
+func readObject(r *http.Request) (object, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	b, err = yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, k8serrors.NewBadRequest(err.Error())
+	}
+	o := object{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, k8serrors.NewBadRequest(err.Error())
+	}
+	return o, nil
+}
@@ -0,0 +1,513 @@
+// Package k8stest provides an in-memory K8S API server for unit tests.
+//
+// The server speaks the REST and watch protocol used by client-go and mk8s - for the
+// core types known to the client-go scheme and for CRDs registered with AddResource.
+// It keeps a revision history, so watches can resume from a resourceVersion and lists
+// can be paginated. Tokens are signed with a generated key, published using OIDC discovery.
+//
+// Faults (410 Gone, 429, slow responses) can be injected to test the retry logic.
+//
+// Not supported: protobuf, field management (apply is a merge patch), admission,
+// consistent snapshots for paginated lists.
+package k8stest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/costinm/mk8s"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultBookmarkInterval is the interval between bookmarks on watches with
+// allowWatchBookmarks. Real servers use about 1 minute.
+const DefaultBookmarkInterval = 1 * time.Second
+
+// Cluster scoped core resources - all other resources in the scheme are namespaced.
+var clusterScoped = map[string]bool{
+	"namespaces": true, "nodes": true, "persistentvolumes": true,
+	"clusterroles": true, "clusterrolebindings": true, "storageclasses": true,
+	"priorityclasses": true, "customresourcedefinitions": true,
+	"certificatesigningrequests": true, "ingressclasses": true, "runtimeclasses": true,
+	"validatingwebhookconfigurations": true, "mutatingwebhookconfigurations": true,
+}
+
+// Server is an in-memory API server.
+type Server struct {
+	// URL of the server - also the issuer of the tokens.
+	URL string
+
+	// BookmarkInterval defaults to DefaultBookmarkInterval.
+	BookmarkInterval time.Duration
+
+	// DisableWatchList makes the server reject streaming lists (sendInitialEvents), like
+	// servers without the WatchList feature gate.
+	DisableWatchList bool
+
+	// Authorizer decides the SubjectAccessReviews. All requests are allowed if nil.
+	Authorizer func(*authorizationv1.SubjectAccessReviewSpec) bool
+
+	srv *httptest.Server
+	key *ecdsa.PrivateKey
+	kid string
+
+	mu sync.Mutex
+
+	// rv is the last revision. Events with a revision <= compacted are no longer available.
+	rv        int64
+	compacted int64
+	uid       int64
+
+	resources map[schema.GroupVersionResource]*resource
+	events    []*event
+	faults    []*Fault
+
+	// changed is closed and replaced on each write, closeWatches when CloseWatches is called.
+	changed      chan struct{}
+	closeWatches chan struct{}
+}
+
+// resource holds the objects of a resource, by namespace/name.
+type resource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	namespaced bool
+	objects    map[string]object
+}
+
+// object is the decoded JSON of a stored object. Stored objects are not modified - writes
+// replace them.
+type object map[string]interface{}
+
+type event struct {
+	rv   int64
+	gvr  schema.GroupVersionResource
+	typ  watch.EventType
+	obj  object
+	prev object
+}
+
+// Fault makes the server fail or delay the matching requests.
+type Fault struct {
+	// Method and Path select the requests - Path is a substring of the URL path. Empty
+	// values match all requests.
+	Method string
+	Path   string
+
+	// Watch selects only watch requests.
+	Watch bool
+
+	// Code is the status returned - 0 to only delay the request. A 410 on a watch is sent
+	// as an ERROR event, like the API server does for expired revisions.
+	Code int
+
+	// RetryAfter is the Retry-After header, in seconds.
+	RetryAfter int
+
+	// Delay is applied before the response.
+	Delay time.Duration
+
+	// Count is the number of requests affected - 0 for all.
+	Count int
+}
+
+// New starts a server, closed when the test ends. The server has the default and
+// kube-system namespaces and the default service account in the default namespace.
+func New(t testing.TB) *Server {
+	s := NewServer()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewServer starts a server - Close must be called when done.
+func NewServer() *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{
+		key:          key,
+		kid:          "k8stest",
+		resources:    map[schema.GroupVersionResource]*resource{},
+		changed:      make(chan struct{}),
+		closeWatches: make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+
+	for _, ns := range []string{"default", "kube-system"} {
+		s.MustCreate(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"},
+			map[string]interface{}{"metadata": map[string]interface{}{"name": ns}})
+	}
+	s.MustCreate(schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"},
+		map[string]interface{}{"metadata": map[string]interface{}{"name": "default", "namespace": "default"}})
+	return s
+}
+
+// Close stops the server and all watches.
+func (s *Server) Close() {
+	s.CloseWatches()
+	s.srv.Close()
+}
+
+// RestConfig returns a config for the server.
+func (s *Server) RestConfig() *rest.Config {
+	return &rest.Config{Host: s.URL}
+}
+
+// Cluster returns a K8SCluster using the server, with the default namespace.
+func (s *Server) Cluster(name string) *mk8s.K8SCluster {
+	return &mk8s.K8SCluster{Name: name, Namespace: "default", RestConfig: s.RestConfig()}
+}
+
+// K8S returns a set with a single cluster named "test" using the server, set as Default.
+func (s *Server) K8S() *mk8s.K8S {
+	k := &mk8s.K8S{}
+	c := s.Cluster("test")
+	k.Default = c
+	k.AddCluster(c, true)
+	return k
+}
+
+// AddResource registers a resource - used for CRDs. Core types from the client-go scheme
+// are registered on first use.
+func (s *Server) AddResource(gvr schema.GroupVersionResource, kind string, namespaced bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resources[gvr] == nil {
+		s.resources[gvr] = &resource{gvr: gvr, kind: kind, namespaced: namespaced, objects: map[string]object{}}
+	}
+}
+
+// resource returns the registered resource, or the core type from the scheme.
+// Called with mu held.
+func (s *Server) resource(gvr schema.GroupVersionResource) *resource {
+	if r := s.resources[gvr]; r != nil {
+		return r
+	}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.GroupVersion() != gvr.GroupVersion() || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		if plural.Resource == gvr.Resource {
+			r := &resource{gvr: gvr, kind: gvk.Kind, namespaced: !clusterScoped[gvr.Resource],
+				objects: map[string]object{}}
+			s.resources[gvr] = r
+			return r
+		}
+	}
+	return nil
+}
+
+// InjectFault adds a fault - faults are checked in order, the first matching is used.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Compact drops the revision history - watches and continue tokens using older revisions
+// get a 410 Gone (Expired) error.
+func (s *Server) Compact() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compacted = s.rv
+	s.events = nil
+	s.notify()
+}
+
+// CloseWatches ends all active watches, like the API server does on timeout.
+func (s *Server) CloseWatches() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.closeWatches)
+	s.closeWatches = make(chan struct{})
+}
+
+// ResourceVersion returns the last revision.
+func (s *Server) ResourceVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprint(s.rv)
+}
+
+// notify wakes up the watches. Called with mu held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// fault returns the first matching fault, decrementing its count.
+func (s *Server) fault(r *http.Request) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if f.Path != "" && !strings.Contains(r.URL.Path, f.Path) {
+			continue
+		}
+		if f.Watch && r.URL.Query().Get("watch") != "true" {
+			continue
+		}
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// ServeHTTP implements the API server paths.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f := s.fault(r); f != nil {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(f.RetryAfter))
+		}
+		if f.Code != 0 {
+			if f.Code == http.StatusGone && r.URL.Query().Get("watch") == "true" {
+				writeJSON(w, http.StatusOK, &mk8s.RawEvent{Type: watch.Error,
+					Object: rawJSON(statusFor(k8serrors.NewResourceExpired("injected fault")))})
+				return
+			}
+			writeStatus(w, k8serrors.FromObject(&metav1.Status{Status: metav1.StatusFailure, Code: int32(f.Code),
+				Reason: reasonFor(f.Code), Message: "injected fault"}))
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/version":
+		writeJSON(w, http.StatusOK, map[string]string{"major": "1", "minor": "30", "gitVersion": "v1.30.0-k8stest"})
+		return
+	case "/readyz", "/livez", "/healthz":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+		return
+	case "/.well-known/openid-configuration":
+		s.serveDiscovery(w)
+		return
+	case "/openid/v1/jwks":
+		s.serveJWKS(w)
+		return
+	case "/api", "/apis":
+		s.serveGroups(w, r.URL.Path)
+		return
+	}
+
+	// /api/v1/... or /apis/GROUP/VERSION/...
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var gv schema.GroupVersion
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		gv, parts = schema.GroupVersion{Version: parts[1]}, parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		gv, parts = schema.GroupVersion{Group: parts[1], Version: parts[2]}, parts[3:]
+	default:
+		writeStatus(w, k8serrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		return
+	}
+	if len(parts) == 0 {
+		s.serveResources(w, gv)
+		return
+	}
+
+	ns := ""
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		ns, parts = parts[1], parts[2:]
+	}
+	gvr := gv.WithResource(parts[0])
+	name, sub := "", ""
+	if len(parts) >= 2 {
+		name = parts[1]
+	}
+	if len(parts) >= 3 {
+		sub = strings.Join(parts[2:], "/")
+	}
+
+	switch gvr {
+	case schema.GroupVersionResource{Group: "authentication.k8s.io", Version: "v1", Resource: "tokenreviews"}:
+		s.serveTokenReview(w, r)
+		return
+	case schema.GroupVersionResource{Group: "authorization.k8s.io", Version: "v1", Resource: "subjectaccessreviews"},
+		schema.GroupVersionResource{Group: "authorization.k8s.io", Version: "v1", Resource: "selfsubjectaccessreviews"}:
+		s.serveAccessReview(w, r, gvr.Resource)
+		return
+	}
+
+	s.mu.Lock()
+	res := s.resource(gvr)
+	s.mu.Unlock()
+	if res == nil || (ns != "" && !res.namespaced) {
+		writeStatus(w, k8serrors.NewNotFound(gvr.GroupResource(), name))
+		return
+	}
+
+	switch {
+	case sub == "token" && gvr.Resource == "serviceaccounts" && r.Method == http.MethodPost:
+		s.serveTokenRequest(w, r, ns, name)
+	case sub != "" && sub != "status":
+		writeStatus(w, k8serrors.NewNotFound(gvr.GroupResource(), name+"/"+sub))
+	case name == "" && r.Method == http.MethodGet && r.URL.Query().Get("watch") == "true":
+		s.serveWatch(w, r, res, ns)
+	case name == "" && r.Method == http.MethodGet:
+		s.serveList(w, r, res, ns)
+	case name == "" && r.Method == http.MethodPost:
+		s.serveCreate(w, r, res, ns)
+	case name == "" && r.Method == http.MethodDelete:
+		s.serveDeleteCollection(w, r, res, ns)
+	case r.Method == http.MethodGet:
+		s.serveGet(w, res, ns, name)
+	case r.Method == http.MethodPut:
+		s.serveUpdate(w, r, res, ns, name, sub)
+	case r.Method == http.MethodPatch:
+		s.servePatch(w, r, res, ns, name, sub)
+	case r.Method == http.MethodDelete:
+		s.serveDelete(w, res, ns, name)
+	default:
+		writeStatus(w, k8serrors.NewMethodNotSupported(gvr.GroupResource(), r.Method))
+	}
+}
+
+// serveGroups returns the API versions (/api) or groups (/apis) of the known resources.
+func (s *Server) serveGroups(w http.ResponseWriter, path string) {
+	if path == "/api" {
+		writeJSON(w, http.StatusOK, &metav1.APIVersions{TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+			Versions: []string{"v1"}})
+		return
+	}
+	groups := map[string]*metav1.APIGroup{}
+	add := func(gv schema.GroupVersion) {
+		if gv.Group == "" {
+			return
+		}
+		g := groups[gv.Group]
+		if g == nil {
+			g = &metav1.APIGroup{Name: gv.Group}
+			groups[gv.Group] = g
+		}
+		for _, v := range g.Versions {
+			if v.Version == gv.Version {
+				return
+			}
+		}
+		gvd := metav1.GroupVersionForDiscovery{GroupVersion: gv.String(), Version: gv.Version}
+		g.Versions = append(g.Versions, gvd)
+		g.PreferredVersion = gvd
+	}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		add(gvk.GroupVersion())
+	}
+	s.mu.Lock()
+	for gvr := range s.resources {
+		add(gvr.GroupVersion())
+	}
+	s.mu.Unlock()
+
+	l := &metav1.APIGroupList{TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"}}
+	for _, g := range groups {
+		l.Groups = append(l.Groups, *g)
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+// serveResources returns the resources in a group version.
+func (s *Server) serveResources(w http.ResponseWriter, gv schema.GroupVersion) {
+	l := &metav1.APIResourceList{TypeMeta: metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: gv.String()}
+	verbs := metav1.Verbs{"create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.GroupVersion() == gv && !strings.HasSuffix(gvk.Kind, "List") {
+			plural, _ := meta.UnsafeGuessKindToResource(gvk)
+			s.resource(plural)
+		}
+	}
+	for gvr, r := range s.resources {
+		if gvr.GroupVersion() == gv {
+			l.APIResources = append(l.APIResources, metav1.APIResource{Name: gvr.Resource, Kind: r.kind,
+				Namespaced: r.namespaced, Verbs: verbs})
+		}
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeStatus(w http.ResponseWriter, err error) {
+	st := statusFor(err)
+	writeJSON(w, int(st.Code), st)
+}
+
+func statusFor(err error) *metav1.Status {
+	st := &metav1.Status{Status: metav1.StatusFailure, Code: http.StatusInternalServerError, Message: err.Error()}
+	if se, ok := err.(k8serrors.APIStatus); ok {
+		s := se.Status()
+		st = &s
+	}
+	st.Kind, st.APIVersion = "Status", "v1"
+	return st
+}
+
+func reasonFor(code int) metav1.StatusReason {
+	switch code {
+	case http.StatusGone:
+		return metav1.StatusReasonExpired
+	case http.StatusTooManyRequests:
+		return metav1.StatusReasonTooManyRequests
+	case http.StatusConflict:
+		return metav1.StatusReasonConflict
+	case http.StatusNotFound:
+		return metav1.StatusReasonNotFound
+	case http.StatusServiceUnavailable:
+		return metav1.StatusReasonServiceUnavailable
+	case http.StatusGatewayTimeout:
+		return metav1.StatusReasonTimeout
+	}
+	return metav1.StatusReasonUnknown
+}
+
+func rawJSON(obj interface{}) []byte {
+	b, _ := json.Marshal(obj)
+	return b
+}
@@ -0,0 +1,125 @@
+package k8stest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/costinm/mk8s"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var cmGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func testCM(name string) *v1.ConfigMap {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+		Labels: map[string]string{"app": "test"}}, Data: map[string]string{"name": name}}
+}
+
+func TestObjects(t *testing.T) {
+	ctx := context.Background()
+	s := New(t)
+	kc := s.Cluster("test")
+	cms := kc.Client().CoreV1().ConfigMaps("default")
+
+	cm, err := cms.Create(ctx, testCM("a"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.UID == "" || cm.ResourceVersion == "" {
+		t.Error("Missing server metadata", cm)
+	}
+	if _, err = cms.Create(ctx, testCM("a"), metav1.CreateOptions{}); !k8serrors.IsAlreadyExists(err) {
+		t.Error("Expected AlreadyExists", err)
+	}
+
+	cm.Data["k"] = "v"
+	cm2, err := cms.Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cms.Update(ctx, cm, metav1.UpdateOptions{}); !k8serrors.IsConflict(err) {
+		t.Error("Expected conflict with the old revision", err)
+	}
+
+	cm3, err := cms.Patch(ctx, "a", types.MergePatchType, []byte(`{"data":{"k":null,"k2":"v2"}}`), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm3.Data["k"] != "" || cm3.Data["k2"] != "v2" || cm3.Data["name"] != "a" || cm3.UID != cm2.UID {
+		t.Error("Unexpected patch result", cm3)
+	}
+
+	// Apply creates or merges.
+	cm4, err := kc.ApplyConfigMap(ctx, "default", "b", map[string]string{"k": "1"}, mk8s.ApplyOptions{})
+	if err != nil || cm4.Data["k"] != "1" {
+		t.Fatal(err, cm4)
+	}
+	cm4, err = kc.ApplyConfigMap(ctx, "default", "b", map[string]string{"k2": "2"}, mk8s.ApplyOptions{})
+	if err != nil || cm4.Data["k"] != "1" || cm4.Data["k2"] != "2" {
+		t.Fatal(err, cm4)
+	}
+
+	if err := cms.Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cms.Get(ctx, "a", metav1.GetOptions{}); !mk8s.Is404(err) {
+		t.Error("Expected 404", err)
+	}
+
+	// CRDs must be registered.
+	crd := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	if _, err := s.Create(crd, map[string]interface{}{"metadata": map[string]interface{}{"name": "w", "namespace": "default"}}); err == nil {
+		t.Error("Expected unknown resource")
+	}
+	s.AddResource(crd, "Widget", true)
+	s.MustCreate(crd, map[string]interface{}{"metadata": map[string]interface{}{"name": "w", "namespace": "default"},
+		"spec": map[string]interface{}{"size": 1}})
+	n := 0
+	_, err = kc.ListRaw(ctx, crd, "default", metav1.ListOptions{}, func(raw json.RawMessage) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Error("Unexpected CRD list", err, n)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	s := New(t)
+	kc := s.Cluster("test")
+	s.MustCreate(cmGVR, testCM("a"))
+
+	// client-go retries 429 with Retry-After.
+	s.InjectFault(Fault{Path: "configmaps", Code: 429, RetryAfter: 1, Count: 1})
+	if _, err := kc.GetCM(ctx, "default", "a"); err != nil {
+		t.Error(err)
+	}
+
+	s.InjectFault(Fault{Method: "GET", Code: 500, Count: 1})
+	if _, err := kc.GetCM(ctx, "default", "a"); !k8serrors.IsInternalError(err) {
+		t.Error("Expected 500", err)
+	}
+
+	s.InjectFault(Fault{Delay: time.Second, Count: 1})
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := kc.GetCM(tctx, "default", "a"); err == nil {
+		t.Error("Expected timeout")
+	}
+
+	s.InjectFault(Fault{Watch: true, Code: 410})
+	err := kc.WatchRaw(ctx, cmGVR, "default", metav1.ListOptions{ResourceVersion: "1"}, func(ev *mk8s.RawEvent) error {
+		return nil
+	})
+	if !k8serrors.IsResourceExpired(err) {
+		t.Error("Expected expired", err)
+	}
+	s.ClearFaults()
+}
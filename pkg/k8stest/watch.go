@@ -0,0 +1,162 @@
+package k8stest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/costinm/mk8s"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// serveWatch sends the events after the requested resourceVersion. Without a
+// resourceVersion (or "0") or with sendInitialEvents, the current objects are sent first
+// as ADDED events - followed by the initial-events-end bookmark if requested.
+func (s *Server) serveWatch(w http.ResponseWriter, r *http.Request, res *resource, ns string) {
+	q := r.URL.Query()
+	match, err := selector(r)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	bookmarks := q.Get("allowWatchBookmarks") == "true"
+	initial := q.Get("sendInitialEvents") == "true"
+	if initial {
+		if s.DisableWatchList {
+			writeStatus(w, k8serrors.NewInvalid(metav1.SchemeGroupVersion.WithKind("ListOptions").GroupKind(), "",
+				field.ErrorList{field.Forbidden(field.NewPath("sendInitialEvents"), "sendInitialEvents is forbidden for watch unless the WatchList feature gate is enabled")}))
+			return
+		}
+		if !bookmarks || q.Get("resourceVersionMatch") != string(metav1.ResourceVersionMatchNotOlderThan) {
+			writeStatus(w, k8serrors.NewInvalid(metav1.SchemeGroupVersion.WithKind("ListOptions").GroupKind(), "",
+				field.ErrorList{field.Forbidden(field.NewPath("sendInitialEvents"),
+					"sendInitialEvents requires allowWatchBookmarks and resourceVersionMatch=NotOlderThan")}))
+			return
+		}
+	}
+	var timeout <-chan time.Time
+	if ts, _ := strconv.Atoi(q.Get("timeoutSeconds")); ts > 0 {
+		timeout = time.After(time.Duration(ts) * time.Second)
+	}
+	interval := s.BookmarkInterval
+	if interval == 0 {
+		interval = DefaultBookmarkInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	send := func(typ watch.EventType, raw []byte) bool {
+		err := writeEvent(w, typ, raw)
+		flush()
+		return err == nil
+	}
+	inNS := func(o object) bool {
+		return ns == "" || o.meta().str("namespace") == ns
+	}
+
+	s.mu.Lock()
+	rvs := q.Get("resourceVersion")
+	from, _ := strconv.ParseInt(rvs, 10, 64)
+	var pending []*mk8s.RawEvent
+	if initial || rvs == "" || rvs == "0" {
+		from = s.rv
+		for _, o := range res.objects {
+			if inNS(o) && match(o) {
+				pending = append(pending, &mk8s.RawEvent{Type: watch.Added, Object: rawJSON(o)})
+			}
+		}
+		if initial {
+			pending = append(pending, &mk8s.RawEvent{Type: watch.Bookmark,
+				Object: bookmark(res, from, map[string]string{metav1.InitialEventsAnnotationKey: "true"})})
+		}
+	}
+	s.mu.Unlock()
+	for _, ev := range pending {
+		if writeEvent(w, ev.Type, ev.Object) != nil {
+			return
+		}
+	}
+	flush()
+
+	for {
+		s.mu.Lock()
+		if from < s.compacted {
+			s.mu.Unlock()
+			send(watch.Error, rawJSON(statusFor(k8serrors.NewResourceExpired("too old resource version: "+
+				strconv.FormatInt(from, 10)+" ("+strconv.FormatInt(s.compacted, 10)+")"))))
+			return
+		}
+		pending = pending[:0]
+		for _, ev := range s.events {
+			if ev.rv <= from || ev.gvr != res.gvr {
+				continue
+			}
+			from = ev.rv
+			cur, prev := inNS(ev.obj) && match(ev.obj), ev.prev != nil && inNS(ev.prev) && match(ev.prev)
+			typ := ev.typ
+			switch {
+			case typ == watch.Deleted && !prev:
+				continue
+			case typ == watch.Modified && cur && !prev:
+				typ = watch.Added
+			case typ == watch.Modified && !cur && prev:
+				typ = watch.Deleted
+			case typ != watch.Deleted && !cur:
+				continue
+			}
+			pending = append(pending, &mk8s.RawEvent{Type: typ, Object: rawJSON(ev.obj)})
+		}
+		if s.rv > from {
+			from = s.rv
+		}
+		changed, closed := s.changed, s.closeWatches
+		s.mu.Unlock()
+
+		for _, ev := range pending {
+			if writeEvent(w, ev.Type, ev.Object) != nil {
+				return
+			}
+		}
+		flush()
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+			if bookmarks {
+				// Events after from may not be sent yet.
+				if !send(watch.Bookmark, bookmark(res, from, nil)) {
+					return
+				}
+			}
+		case <-closed:
+			return
+		case <-timeout:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// bookmark returns a bookmark object for a revision.
+func bookmark(res *resource, rv int64, annotations map[string]string) []byte {
+	return rawJSON(&metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: res.gvr.GroupVersion().String(), Kind: res.kind},
+		ObjectMeta: metav1.ObjectMeta{ResourceVersion: strconv.FormatInt(rv, 10),
+			Annotations: annotations}})
+}
+
+func writeEvent(w http.ResponseWriter, typ watch.EventType, raw []byte) error {
+	_, err := w.Write(append(rawJSON(&mk8s.RawEvent{Type: typ, Object: raw}), '\n'))
+	return err
+}
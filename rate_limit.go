@@ -0,0 +1,284 @@
+package mk8s
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/rest"
+)
+
+// Response headers set by the API server when API Priority and Fairness handled the request.
+// A 429 with these headers was rejected by the cluster, without them it was rejected by a
+// proxy - like the Connect Gateway project quota.
+const (
+	apfPriorityLevelHeader = "X-Kubernetes-PF-PriorityLevel-UID"
+	apfFlowSchemaHeader    = "X-Kubernetes-PF-FlowSchema-UID"
+)
+
+const (
+	// After a throttled response the QPS is halved - at most once per rateDecreaseInterval,
+	// a burst of 429s is a single signal.
+	rateDecreaseInterval = 1 * time.Second
+
+	// The QPS is increased by 10% every rateIncreaseInterval if requests are made and
+	// none was throttled.
+	rateIncreaseInterval = 5 * time.Second
+
+	// Max pause for a Retry-After header.
+	maxRetryAfter = 1 * time.Minute
+)
+
+// RateLimitOptions configure an AdaptiveRateLimiter.
+type RateLimitOptions struct {
+	// QPS is the initial rate. Defaults to K8S.QPS or the client default (5).
+	QPS float32
+
+	// MinQPS and MaxQPS bound the adaptive rate. Default to QPS/10 and 10*QPS.
+	MinQPS float32
+	MaxQPS float32
+
+	// Burst defaults to K8S.Burst or the client default (10).
+	Burst int
+
+	// Parent is an optional limiter shared by multiple clusters - each request must be
+	// allowed by both. Throttling by a proxy (429 without APF headers) reduces the parent.
+	Parent *AdaptiveRateLimiter
+}
+
+// AdaptiveRateLimiter is a client side rate limiter (flowcontrol.RateLimiter) that adjusts
+// the QPS based on the server responses. A 429 or Retry-After halves the QPS and pauses all
+// requests for the Retry-After duration; the QPS is slowly increased back up to MaxQPS while
+// requests succeed.
+//
+// The limiter gets the responses from a transport installed by K8S.AddCluster. It can be set
+// as the RestConfig.RateLimiter of a cluster before adding it, or created for all clusters
+// using K8S.RateLimit.
+type AdaptiveRateLimiter struct {
+	limiter *rate.Limiter
+	parent  *AdaptiveRateLimiter
+
+	min, max float64
+
+	mu           sync.Mutex
+	qps          float64
+	pausedUntil  time.Time
+	lastDecrease time.Time
+	lastIncrease time.Time
+	throttled    bool
+}
+
+// NewAdaptiveRateLimiter creates a limiter - zero options use the client defaults.
+func NewAdaptiveRateLimiter(opts RateLimitOptions) *AdaptiveRateLimiter {
+	qps := float64(opts.QPS)
+	if qps <= 0 {
+		qps = float64(rest.DefaultQPS)
+	}
+	burst := opts.Burst
+	if burst <= 0 {
+		burst = rest.DefaultBurst
+	}
+	l := &AdaptiveRateLimiter{
+		limiter:      rate.NewLimiter(rate.Limit(qps), burst),
+		parent:       opts.Parent,
+		qps:          qps,
+		min:          float64(opts.MinQPS),
+		max:          float64(opts.MaxQPS),
+		lastIncrease: time.Now(),
+	}
+	if l.min <= 0 {
+		l.min = qps / 10
+	}
+	if l.max <= 0 {
+		l.max = qps * 10
+	}
+	return l
+}
+
+// QPS returns the current rate.
+func (l *AdaptiveRateLimiter) QPS() float32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return float32(l.qps)
+}
+
+// TryAccept returns true if a request can be made now.
+func (l *AdaptiveRateLimiter) TryAccept() bool {
+	if l.pause() > 0 {
+		return false
+	}
+	// The parent token is only taken if this limiter allows the request.
+	r := l.limiter.Reserve()
+	if !r.OK() || r.Delay() > 0 {
+		r.Cancel()
+		return false
+	}
+	if l.parent != nil && !l.parent.TryAccept() {
+		r.Cancel()
+		return false
+	}
+	return true
+}
+
+// Accept blocks until a request can be made.
+func (l *AdaptiveRateLimiter) Accept() {
+	l.Wait(context.Background())
+}
+
+// Wait blocks until a request can be made or ctx is done.
+func (l *AdaptiveRateLimiter) Wait(ctx context.Context) error {
+	for {
+		d := l.pause()
+		if d <= 0 {
+			break
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// Like TryAccept, the parent token is only taken once this limiter allows the request.
+	if err := l.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	if l.parent != nil {
+		return l.parent.Wait(ctx)
+	}
+	return nil
+}
+
+// Stop is a no-op - required by the flowcontrol.RateLimiter interface.
+func (l *AdaptiveRateLimiter) Stop() {}
+
+// pause returns the remaining Retry-After time.
+func (l *AdaptiveRateLimiter) pause() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Until(l.pausedUntil)
+}
+
+// observe adjusts the rate based on a response.
+func (l *AdaptiveRateLimiter) observe(res *http.Response) {
+	if res == nil {
+		return
+	}
+	retryAfter := time.Duration(0)
+	if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s > 0 {
+		retryAfter = min(time.Duration(s)*time.Second, maxRetryAfter)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		if l.parent != nil && res.Header.Get(apfPriorityLevelHeader) == "" &&
+			res.Header.Get(apfFlowSchemaHeader) == "" {
+			l.parent.decrease(retryAfter)
+		}
+		l.decrease(retryAfter)
+	case retryAfter > 0:
+		// 503 or other responses asking the client to retry later.
+		l.decrease(retryAfter)
+	case res.StatusCode < 500:
+		l.increase()
+		if l.parent != nil {
+			l.parent.increase()
+		}
+	}
+}
+
+func (l *AdaptiveRateLimiter) decrease(retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if until := now.Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	l.throttled = true
+	if now.Sub(l.lastDecrease) < rateDecreaseInterval {
+		return
+	}
+	l.lastDecrease, l.lastIncrease = now, now
+	l.setQPS(max(l.qps/2, l.min), now)
+}
+
+func (l *AdaptiveRateLimiter) increase() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastIncrease) < rateIncreaseInterval {
+		return
+	}
+	l.lastIncrease = now
+	if l.throttled {
+		// Start a new interval without throttling.
+		l.throttled = false
+		return
+	}
+	if l.qps < l.max {
+		l.setQPS(min(l.qps*1.1, l.max), now)
+	}
+}
+
+// setQPS is called with mu held.
+func (l *AdaptiveRateLimiter) setQPS(qps float64, now time.Time) {
+	if qps != l.qps {
+		logger.Debug("RateLimitChanged", "qps", qps, "prev", l.qps)
+	}
+	l.qps = qps
+	l.limiter.SetLimitAt(now, rate.Limit(qps))
+}
+
+// rateLimit installs the transport reporting responses to the AdaptiveRateLimiter of the
+// cluster - creating one if K8S.RateLimit is set and the cluster has no limiter.
+func (kr *K8S) rateLimit(c *K8SCluster) {
+	rc := c.RestConfig
+	if rc == nil {
+		return
+	}
+	l, ok := rc.RateLimiter.(*AdaptiveRateLimiter)
+	if !ok {
+		if kr.RateLimit == nil || rc.RateLimiter != nil {
+			return
+		}
+		opts := *kr.RateLimit
+		if opts.QPS == 0 {
+			opts.QPS = kr.QPS
+		}
+		if opts.Burst == 0 {
+			opts.Burst = kr.Burst
+		}
+		l = NewAdaptiveRateLimiter(opts)
+	}
+	if !c.rateLimiter.CompareAndSwap(nil, l) {
+		return
+	}
+	rc.RateLimiter = l
+
+	wt := rc.WrapTransport
+	rc.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wt != nil {
+			rt = wt(rt)
+		}
+		return &rateLimitTransport{rt: rt, l: l}
+	}
+}
+
+// RateLimiter returns the AdaptiveRateLimiter of the cluster, or nil if it uses a fixed
+// QPS.
+func (kc *K8SCluster) RateLimiter() *AdaptiveRateLimiter {
+	return kc.rateLimiter.Load()
+}
+
+type rateLimitTransport struct {
+	rt http.RoundTripper
+	l  *AdaptiveRateLimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	t.l.observe(res)
+	return res, err
+}
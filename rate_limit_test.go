@@ -0,0 +1,106 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	// Returns a 429 for the first request, with or without APF headers.
+	throttled := func(apf bool) *K8SCluster {
+		n := atomic.Int32{}
+		return httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if apf {
+				w.Header().Set(apfPriorityLevelHeader, "pl")
+			}
+			if n.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			json.NewEncoder(w).Encode(testCM("a", 1))
+		})
+	}
+
+	shared := NewAdaptiveRateLimiter(RateLimitOptions{QPS: 40, MaxQPS: 40, Burst: 40})
+	k := &K8S{RateLimit: &RateLimitOptions{QPS: 100, Parent: shared}}
+
+	// Throttled by the API server - only the cluster limiter is reduced.
+	c1 := throttled(true)
+	c1.Name = "c1"
+	k.AddCluster(c1, true)
+	if _, err := c1.GetCM(ctx, "default", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if c1.RateLimiter().QPS() != 50 || shared.QPS() != 40 {
+		t.Error("Unexpected QPS", c1.RateLimiter().QPS(), shared.QPS())
+	}
+
+	// Throttled by a proxy - the shared budget is also reduced.
+	c2 := throttled(false)
+	c2.Name = "c2"
+	k.AddCluster(c2, true)
+	if _, err := c2.GetCM(ctx, "default", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if c2.RateLimiter().QPS() != 50 || shared.QPS() != 20 {
+		t.Error("Unexpected QPS", c2.RateLimiter().QPS(), shared.QPS())
+	}
+
+	// Successful requests after an interval without throttling increase the rate.
+	l := c2.RateLimiter()
+	for i := 0; i < 2; i++ {
+		l.mu.Lock()
+		l.lastIncrease = time.Now().Add(-rateIncreaseInterval)
+		l.mu.Unlock()
+		if _, err := c2.GetCM(ctx, "default", "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.QPS() != 55 {
+		t.Error("Expected QPS increase", l.QPS())
+	}
+
+	// Retry-After pauses all requests.
+	l.observe(&http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"1"}}})
+	if l.TryAccept() {
+		t.Error("Expected pause")
+	}
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(tctx); err == nil {
+		t.Error("Expected wait to time out")
+	}
+
+	// Requests denied by the cluster limiter don't use the shared budget.
+	parent := NewAdaptiveRateLimiter(RateLimitOptions{QPS: 1, Burst: 2})
+	child := NewAdaptiveRateLimiter(RateLimitOptions{QPS: 1, Burst: 1, Parent: parent})
+	for i := 0; i < 10; i++ {
+		if child.TryAccept() != (i == 0) {
+			t.Fatal("Unexpected TryAccept", i)
+		}
+	}
+	if !parent.TryAccept() {
+		t.Error("Parent budget used by denied requests")
+	}
+
+	// Same for Wait - a throttled cluster doesn't block the shared budget.
+	parent = NewAdaptiveRateLimiter(RateLimitOptions{QPS: 0.1, Burst: 2})
+	child = NewAdaptiveRateLimiter(RateLimitOptions{QPS: 0.1, Burst: 1, Parent: parent})
+	child.Accept()
+	tctx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := child.Wait(tctx); err == nil {
+		t.Error("Expected wait to time out")
+	}
+	if !parent.TryAccept() {
+		t.Error("Parent budget used by a throttled cluster")
+	}
+}
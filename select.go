@@ -0,0 +1,123 @@
+package mk8s
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LabelWeight is the label holding the relative weight of a cluster, used by Select with
+// Weighted. Clusters without the label have weight 1.
+const LabelWeight = "weight"
+
+// SelectOptions are the policies used by Select to order the clusters.
+type SelectOptions struct {
+	// Region and Zone order the clusters in the same zone first, followed by the clusters in
+	// the same region. Region defaults to K8S.Region, and also matches the zones in the
+	// region - us-central1 matches us-central1-c, not us-central10.
+	Region string
+	Zone   string
+
+	// ExcludeUnhealthy skips the clusters that failed the last health check. Clusters that
+	// were not checked are included.
+	ExcludeUnhealthy bool
+
+	// Weighted shuffles the clusters with the same locality, a cluster being first with a
+	// probability proportional to its LabelWeight. Clusters with weight 0 are last.
+	// Without it, the clusters with the same locality are sorted by name.
+	Weighted bool
+}
+
+// Select returns the clusters matching a label selector, ordered using the options - the
+// first is the preferred cluster. An empty selector matches all clusters.
+func (kr *K8S) Select(selector string, opts SelectOptions) ([]*K8SCluster, error) {
+	cl, err := kr.ClustersMatching(selector)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Region == "" {
+		opts.Region = kr.Region
+	}
+
+	type ranked struct {
+		c        *K8SCluster
+		locality int
+		key      float64
+	}
+	res := make([]ranked, 0, len(cl))
+	for _, c := range cl {
+		if opts.ExcludeUnhealthy {
+			if h := c.Health(); h != nil && !h.Healthy {
+				continue
+			}
+		}
+		r := ranked{c: c, locality: opts.locality(c)}
+		if opts.Weighted {
+			r.key = weightKey(c)
+		}
+		res = append(res, r)
+	}
+
+	// Stable - clusters with the same locality and key keep the name order.
+	slices.SortStableFunc(res, func(a, b ranked) int {
+		if a.locality != b.locality {
+			return a.locality - b.locality
+		}
+		if a.key > b.key {
+			return -1
+		}
+		if a.key < b.key {
+			return 1
+		}
+		return 0
+	})
+
+	sel := make([]*K8SCluster, len(res))
+	for i, r := range res {
+		sel[i] = r.c
+	}
+	return sel, nil
+}
+
+// SelectOne returns the preferred cluster matching the selector, or nil if none matches.
+func (kr *K8S) SelectOne(selector string, opts SelectOptions) *K8SCluster {
+	cl, err := kr.Select(selector, opts)
+	if err != nil || len(cl) == 0 {
+		return nil
+	}
+	return cl[0]
+}
+
+// locality returns 0 for clusters in the zone, 1 in the region and 2 for other clusters.
+func (opts *SelectOptions) locality(c *K8SCluster) int {
+	l := c.LabelSet()
+	switch {
+	case opts.Zone != "" && (l[LabelZone] == opts.Zone || l[LabelLocation] == opts.Zone):
+		return 0
+	case opts.Region != "" && (l[LabelRegion] == opts.Region || inRegion(l[LabelLocation], opts.Region)):
+		return 1
+	}
+	return 2
+}
+
+// inRegion returns true if the location is the region or a zone in the region.
+func inRegion(location, region string) bool {
+	return location == region || strings.HasPrefix(location, region+"-")
+}
+
+// weightKey returns a random key for weighted ordering - sorting by key in decreasing order
+// is a weighted random permutation.
+func weightKey(c *K8SCluster) float64 {
+	w := 1.0
+	if ws, f := c.LabelSet()[LabelWeight]; f {
+		if v, err := strconv.ParseFloat(ws, 64); err == nil {
+			w = v
+		}
+	}
+	if w <= 0 || math.IsNaN(w) {
+		return -1
+	}
+	return math.Pow(rand.Float64(), 1/w)
+}
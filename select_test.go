@@ -0,0 +1,63 @@
+package mk8s
+
+import (
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	k := &K8S{Region: "us-central1"}
+	for _, n := range []string{"gke_p_us-east1_e", "gke_p_us-central1_r", "gke_p_us-central1-c_z",
+		"gke_p_us-central1-b_z2", "gke_p_us-central10_x", "other"} {
+		k.AddCluster(&K8SCluster{Name: n, Labels: map[string]string{"env": "prod"}}, true)
+	}
+
+	names := func(cl []*K8SCluster) []string {
+		res := []string{}
+		for _, c := range cl {
+			res = append(res, c.Name)
+		}
+		return res
+	}
+	check := func(cl []*K8SCluster, err error, want ...string) {
+		t.Helper()
+		got := names(cl)
+		if err != nil || len(got) != len(want) {
+			t.Fatal("Unexpected selection", err, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatal("Unexpected selection", got, want)
+			}
+		}
+	}
+
+	// Same zone, same region, then by name.
+	cl, err := k.Select("env=prod", SelectOptions{Zone: "us-central1-c"})
+	check(cl, err, "gke_p_us-central1-c_z", "gke_p_us-central1-b_z2", "gke_p_us-central1_r",
+		"gke_p_us-central10_x", "gke_p_us-east1_e", "other")
+
+	k.Cluster("gke_p_us-central1-c_z").health.Store(&ClusterHealth{Healthy: false, Failures: 1})
+	cl, err = k.Select("region=us-central1", SelectOptions{ExcludeUnhealthy: true})
+	check(cl, err, "gke_p_us-central1-b_z2", "gke_p_us-central1_r")
+
+	if c := k.SelectOne("region=us-west1", SelectOptions{}); c != nil {
+		t.Error("Unexpected match", c)
+	}
+
+	// Weighted random within the same locality, weight 0 last.
+	k2 := &K8S{}
+	k2.AddCluster(&K8SCluster{Name: "a", Labels: map[string]string{LabelWeight: "3"}}, true)
+	k2.AddCluster(&K8SCluster{Name: "b"}, true)
+	k2.AddCluster(&K8SCluster{Name: "c", Labels: map[string]string{LabelWeight: "0"}}, true)
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		cl, err := k2.Select("", SelectOptions{Weighted: true})
+		if err != nil || len(cl) != 3 || cl[2].Name != "c" {
+			t.Fatal("Unexpected selection", err, names(cl))
+		}
+		first[cl[0].Name]++
+	}
+	if first["a"] < 650 || first["a"] > 850 {
+		t.Error("Unexpected distribution", first)
+	}
+}
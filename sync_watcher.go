@@ -0,0 +1,295 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	meshv1 "github.com/costinm/mk8s/pkg/apis/mesh/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// SyncStore persists the SyncStatus for a watcher.
+type SyncStore interface {
+	// Load returns the saved status, or nil if the key was never saved.
+	Load(key string) (*meshv1.SyncStatus, error)
+
+	Save(key string, st *meshv1.SyncStatus) error
+}
+
+// FileSyncStore saves each SyncStatus as a JSON file in a directory.
+type FileSyncStore struct {
+	Dir string
+}
+
+func (fs *FileSyncStore) file(key string) string {
+	return filepath.Join(fs.Dir, url.PathEscape(key)+".json")
+}
+
+func (fs *FileSyncStore) Load(key string) (*meshv1.SyncStatus, error) {
+	b, err := os.ReadFile(fs.file(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	st := &meshv1.SyncStatus{}
+	err = json.Unmarshal(b, st)
+	return st, err
+}
+
+// Save writes to a temp file and renames it, so a crash will not leave a partial status.
+func (fs *FileSyncStore) Save(key string, st *meshv1.SyncStatus) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(fs.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	f := fs.file(key)
+	err = os.WriteFile(f+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f+".tmp", f)
+}
+
+// SyncWatcher watches a resource, saving the last handled resourceVersion in a SyncStore.
+//
+// After a restart, the watch resumes from the saved revision - the server will send
+// all the changes since then, including deletions. A (paged) re-list is only done if the
+// revision is too old and the server returns 410 Gone.
+//
+// Delivery is at-least-once: the revision is saved after the Handler returns, so events
+// handled just before a crash may be delivered again.
+type SyncWatcher struct {
+	Cluster *K8SCluster
+
+	GVR schema.GroupVersionResource
+
+	// Namespace to watch - empty for all namespaces.
+	Namespace string
+
+	// ListOptions can set label and field selectors and the page size. The revision,
+	// continue token and watch options are set by the watcher.
+	ListOptions metav1.ListOptions
+
+	Store SyncStore
+
+	// Handler is called for each event. Objects from a list are delivered as ADDED.
+	// BOOKMARK events are not delivered.
+	Handler func(*RawEvent) error
+
+	// OnRelist is called when the saved revision is too old and a full list will be done.
+	// Handlers keeping state should remove objects that are not seen in the list.
+	OnRelist func()
+
+	// OnListDone is called after the last page of a list was handled.
+	OnListDone func()
+
+	// SaveInterval is the minimum interval between saving the revision after watch events.
+	// Bookmarks, list pages and the end of a watch always save. Default 1s.
+	SaveInterval time.Duration
+
+	// Status is the current sync status, loaded from the Store by Run.
+	Status *meshv1.SyncStatus
+
+	lastSave time.Time
+}
+
+// Key returns the key used in the SyncStore - cluster, GVR and namespace.
+func (w *SyncWatcher) Key() string {
+	return strings.Join([]string{w.Cluster.Name, w.GVR.Group, w.GVR.Version, w.GVR.Resource, w.Namespace}, "_")
+}
+
+// Run lists and watches until ctx is done, or the Handler or Store return an error. Other
+// errors - connection failures, 5xx, 429 - are retried with DefaultWatchBackoff, and a
+// 410 Gone restarts with a list.
+//
+// Run can be called again after an error - it will resume from the saved state.
+func (w *SyncWatcher) Run(ctx context.Context) error {
+	if w.Store == nil {
+		return errors.New("missing SyncStore")
+	}
+	if w.Status == nil {
+		st, err := w.Store.Load(w.Key())
+		if err != nil {
+			return err
+		}
+		if st == nil {
+			st = &meshv1.SyncStatus{Cluster: w.Cluster.Name, Group: w.GVR.Group,
+				Version: w.GVR.Version, Resource: w.GVR.Resource, Namespace: w.Namespace}
+		}
+		w.Status = st
+	}
+	st := w.Status
+	if st.ResourceVersion != "" {
+		logger.Info("SyncResume", "key", w.Key(), "rv", st.ResourceVersion, "continue", st.Continue != "")
+	}
+
+	wr := &watchRestarter{}
+	b := DefaultWatchBackoff
+	for ctx.Err() == nil {
+		var err error
+		rv := st.ResourceVersion
+		if st.ResourceVersion == "" || st.Continue != "" {
+			err = w.list(ctx)
+		} else {
+			wr.start()
+			err = w.watch(ctx)
+			if err == nil {
+				// Closed by the server.
+				wr.wait(ctx)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		var se *stopError
+		if errors.As(err, &se) {
+			return se.err
+		}
+		if !k8serrors.IsResourceExpired(err) && !k8serrors.IsGone(err) {
+			if st.ResourceVersion != rv {
+				// Made progress since the last error.
+				b = DefaultWatchBackoff
+			}
+			d := b.Step()
+			logger.Info("SyncRetry", "key", w.Key(), "rv", st.ResourceVersion, "err", err, "delay", d)
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		logger.Info("SyncExpired", "key", w.Key(), "rv", st.ResourceVersion, "continue", st.Continue != "", "err", err)
+		st.ResourceVersion = ""
+		st.Continue = ""
+		err = w.save(true)
+		if err != nil {
+			return err
+		}
+		if w.OnRelist != nil {
+			w.OnRelist()
+		}
+	}
+
+	w.save(true)
+	return ctx.Err()
+}
+
+// list does a paged list, starting or continuing from the saved continue token.
+func (w *SyncWatcher) list(ctx context.Context) error {
+	st := w.Status
+	opts := w.ListOptions
+	if opts.Limit == 0 {
+		opts.Limit = 500
+	}
+
+	rc, err := w.Cluster.RestClient(w.GVR.Group, w.GVR.Version)
+	if err != nil {
+		return err
+	}
+
+	// Each page is a separate request - so the progress can be saved after each page.
+	for {
+		opts.Continue = st.Continue
+		lm, err := listPage(ctx, rc, w.GVR.Resource, w.Namespace, opts, func(raw json.RawMessage) error {
+			return stop(w.Handler(&RawEvent{Type: watch.Added, Object: raw}))
+		})
+		if err != nil {
+			return err
+		}
+		st.ResourceVersion = lm.ResourceVersion
+		st.Continue = lm.Continue
+		err = w.save(true)
+		if err != nil {
+			return stop(err)
+		}
+		if st.Continue == "" {
+			if w.OnListDone != nil {
+				w.OnListDone()
+			}
+			return nil
+		}
+	}
+}
+
+// watch runs a single watch from the saved revision, until the server closes it.
+func (w *SyncWatcher) watch(ctx context.Context) error {
+	st := w.Status
+	opts := w.ListOptions
+	opts.Limit = 0
+	opts.Continue = ""
+	opts.ResourceVersion = st.ResourceVersion
+	opts.AllowWatchBookmarks = true
+
+	err := w.Cluster.WatchRaw(ctx, w.GVR, w.Namespace, opts, func(ev *RawEvent) error {
+		if ev.Type != watch.Bookmark {
+			err := w.Handler(ev)
+			if err != nil {
+				return stop(err)
+			}
+		}
+		pom, err := ev.Meta()
+		if err != nil {
+			return err
+		}
+		st.ResourceVersion = pom.ResourceVersion
+		return stop(w.save(ev.Type == watch.Bookmark))
+	})
+	if serr := w.save(true); err == nil {
+		err = stop(serr)
+	}
+	return err
+}
+
+// stopError wraps the errors of the Handler and Store, which stop Run instead of being
+// retried.
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stopError) Unwrap() error {
+	return e.err
+}
+
+func stop(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stopError{err: err}
+}
+
+func (w *SyncWatcher) save(force bool) error {
+	si := w.SaveInterval
+	if si == 0 {
+		si = time.Second
+	}
+	now := time.Now()
+	if !force && now.Sub(w.lastSave) < si {
+		return nil
+	}
+	w.lastSave = now
+	w.Status.LastSync = metav1.NewTime(now)
+	return w.Store.Save(w.Key(), w.Status)
+}
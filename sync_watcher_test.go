@@ -0,0 +1,108 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestSyncWatcher(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+
+	lists := 0
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("watch") != "true" {
+			lists++
+			json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+				Items: []v1.ConfigMap{testCM("a", 5)}})
+			return
+		}
+		switch q.Get("resourceVersion") {
+		case "10":
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "ADDED", "object": testCM("b", 11)})
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "BOOKMARK", "object": testCM("", 12)})
+		case "12":
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "ERROR", "object": metav1.Status{
+				Status: "Failure", Reason: metav1.StatusReasonExpired, Code: 410}})
+		}
+	})
+
+	store := &FileSyncStore{Dir: t.TempDir()}
+	run := func(w *SyncWatcher, stopAfter int) []string {
+		names := []string{}
+		wctx, wcf := context.WithCancel(ctx)
+		defer wcf()
+		w.Handler = func(ev *RawEvent) error {
+			m, _ := ev.Meta()
+			names = append(names, m.Name)
+			if len(names) == stopAfter {
+				wcf()
+			}
+			return nil
+		}
+		err := w.Run(wctx)
+		if ctx.Err() != nil || err != context.Canceled {
+			t.Fatal("Unexpected exit", err)
+		}
+		return names
+	}
+
+	// First run: list, watch from 10 and get a bookmark for 12, watch from 12 which
+	// is expired - and list again.
+	relist := false
+	w := &SyncWatcher{Cluster: kc, GVR: cmGVR, Namespace: "default", Store: store,
+		OnRelist: func() { relist = true }}
+	names := run(w, 3)
+	if !relist || lists != 2 || len(names) != 3 {
+		t.Fatal("Expected relist after 410", relist, lists, names)
+	}
+
+	st, _ := store.Load(w.Key())
+	if st == nil || st.ResourceVersion != "10" {
+		t.Fatal("Expected saved status", st)
+	}
+
+	// Restart: resume the watch from the saved revision, without a list.
+	w = &SyncWatcher{Cluster: kc, GVR: cmGVR, Namespace: "default", Store: store}
+	names = run(w, 1)
+	if lists != 2 || names[0] != "b" {
+		t.Error("Expected resume from saved revision", lists, names)
+	}
+}
+
+func TestSyncWatcherRetry(t *testing.T) {
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	defer func(b wait.Backoff) { DefaultWatchBackoff = b }(DefaultWatchBackoff)
+	DefaultWatchBackoff = wait.Backoff{Duration: 10 * time.Millisecond, Factor: 2, Steps: 100, Cap: time.Second}
+
+	var requests atomic.Int32
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		// Server errors are retried.
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(v1.ConfigMapList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+			Items: []v1.ConfigMap{testCM("a", 5)}})
+	})
+
+	herr := errors.New("handler failed")
+	w := &SyncWatcher{Cluster: kc, GVR: cmGVR, Namespace: "default", Store: &FileSyncStore{Dir: t.TempDir()},
+		Handler: func(ev *RawEvent) error {
+			return herr
+		}}
+	if err := w.Run(ctx); err != herr || requests.Load() != 3 {
+		t.Fatal("Expected handler error after retries", err, requests.Load())
+	}
+}
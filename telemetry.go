@@ -0,0 +1,185 @@
+package mk8s
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Telemetry receives the API requests of all clusters in a K8S set. The gcp module
+// provides an OpenTelemetry implementation - the core library doesn't depend on OTel.
+type Telemetry interface {
+	// StartRequest is called before a request is sent. The returned context is used for
+	// the request - it can hold a span. The returned function is called with the result.
+	StartRequest(ctx context.Context, ri *RequestInfo) (context.Context, func(*RequestInfo))
+
+	// RateLimiterWait records the time a request waited for the client side rate limiter
+	// (QPS and Burst).
+	RateLimiterWait(ctx context.Context, cluster string, d time.Duration)
+}
+
+// RequestInfo describes an API request.
+type RequestInfo struct {
+	Cluster string
+
+	// Verb is the K8S verb - get, list, watch, create, update, patch, delete or
+	// deletecollection. Requests for non-resource paths use the lowercase http method.
+	Verb string
+
+	// Group and Resource of the request, including the subresource ("pods/log").
+	// Empty for non-resource paths like /version.
+	Group     string
+	Resource  string
+	Namespace string
+
+	// Code is the response status - 0 if the request failed without a response.
+	Code int
+
+	Duration time.Duration
+	Err      error
+}
+
+// SetTelemetry sets the Telemetry of the set and instruments the existing clusters.
+// Clients created before the call are not instrumented.
+func (kr *K8S) SetTelemetry(t Telemetry) {
+	kr.Telemetry = t
+	for _, c := range kr.Clusters() {
+		kr.instrument(c)
+	}
+	if def := kr.DefaultCluster(); def != nil {
+		kr.instrument(def)
+	}
+}
+
+// instrument wraps the transport and rate limiter of the cluster config, if the set has a
+// Telemetry. Called when the cluster is added, before the clients are created.
+func (kr *K8S) instrument(c *K8SCluster) {
+	t := kr.Telemetry
+	if t == nil || c.RestConfig == nil || !c.instrumented.CompareAndSwap(false, true) {
+		return
+	}
+	rc := c.RestConfig
+	name := c.Name
+
+	wt := rc.WrapTransport
+	rc.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wt != nil {
+			rt = wt(rt)
+		}
+		return &telemetryTransport{rt: rt, t: t, cluster: name}
+	}
+
+	rl := rc.RateLimiter
+	if rl == nil {
+		qps, burst := rc.QPS, rc.Burst
+		if qps == 0 {
+			qps = rest.DefaultQPS
+		}
+		if burst == 0 {
+			burst = rest.DefaultBurst
+		}
+		if qps > 0 {
+			rl = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+		}
+	}
+	if rl != nil {
+		rc.RateLimiter = &timedRateLimiter{RateLimiter: rl, t: t, cluster: name}
+	}
+}
+
+// telemetryTransport reports each request to the Telemetry.
+type telemetryTransport struct {
+	rt      http.RoundTripper
+	t       Telemetry
+	cluster string
+}
+
+func (tt *telemetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ri := requestInfo(req)
+	ri.Cluster = tt.cluster
+	ctx, done := tt.t.StartRequest(req.Context(), ri)
+
+	start := time.Now()
+	res, err := tt.rt.RoundTrip(req.WithContext(ctx))
+	ri.Duration = time.Since(start)
+	ri.Err = err
+	if res != nil {
+		ri.Code = res.StatusCode
+	}
+	done(ri)
+	return res, err
+}
+
+// requestInfo parses the verb and resource from the request path, like the API server.
+func requestInfo(req *http.Request) *RequestInfo {
+	ri := &RequestInfo{Verb: strings.ToLower(req.Method)}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	// /api/v1/... or /apis/GROUP/VERSION/...
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		ri.Group = parts[1]
+		parts = parts[3:]
+	default:
+		return ri
+	}
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		ri.Namespace = parts[1]
+		parts = parts[2:]
+	}
+	ri.Resource = parts[0]
+	if len(parts) >= 3 {
+		ri.Resource += "/" + parts[2]
+	}
+	hasName := len(parts) >= 2
+
+	switch req.Method {
+	case http.MethodGet:
+		switch {
+		case req.URL.Query().Get("watch") == "true":
+			ri.Verb = "watch"
+		case hasName:
+			ri.Verb = "get"
+		default:
+			ri.Verb = "list"
+		}
+	case http.MethodPost:
+		ri.Verb = "create"
+	case http.MethodPut:
+		ri.Verb = "update"
+	case http.MethodPatch:
+		ri.Verb = "patch"
+	case http.MethodDelete:
+		ri.Verb = "delete"
+		if !hasName {
+			ri.Verb = "deletecollection"
+		}
+	}
+	return ri
+}
+
+// timedRateLimiter reports the time spent waiting for the rate limiter.
+type timedRateLimiter struct {
+	flowcontrol.RateLimiter
+	t       Telemetry
+	cluster string
+}
+
+func (tl *timedRateLimiter) Accept() {
+	start := time.Now()
+	tl.RateLimiter.Accept()
+	tl.t.RateLimiterWait(context.Background(), tl.cluster, time.Since(start))
+}
+
+func (tl *timedRateLimiter) Wait(ctx context.Context) error {
+	start := time.Now()
+	err := tl.RateLimiter.Wait(ctx)
+	tl.t.RateLimiterWait(ctx, tl.cluster, time.Since(start))
+	return err
+}
@@ -0,0 +1,81 @@
+package mk8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type testTelemetry struct {
+	mu       sync.Mutex
+	requests []string
+	waits    int
+}
+
+func (tt *testTelemetry) StartRequest(ctx context.Context, ri *RequestInfo) (context.Context, func(*RequestInfo)) {
+	return ctx, func(ri *RequestInfo) {
+		tt.mu.Lock()
+		tt.requests = append(tt.requests, ri.Cluster+" "+ri.Verb+" "+ri.Group+" "+ri.Resource+" "+
+			ri.Namespace+" "+http.StatusText(ri.Code))
+		tt.mu.Unlock()
+	}
+}
+
+func (tt *testTelemetry) RateLimiterWait(ctx context.Context, cluster string, d time.Duration) {
+	tt.mu.Lock()
+	tt.waits++
+	tt.mu.Unlock()
+}
+
+func TestTelemetry(t *testing.T) {
+	ctx := context.Background()
+
+	kc := httpCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v1/namespaces/default/configmaps/b" {
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: "Failure", Reason: metav1.StatusReasonNotFound, Code: 404})
+			return
+		}
+		json.NewEncoder(w).Encode(v1.ConfigMapList{})
+	})
+	tt := &testTelemetry{}
+	k := &K8S{Telemetry: tt}
+	k.AddCluster(kc, true)
+
+	kc.GetCM(ctx, "default", "b")
+	kc.Client().CoreV1().ConfigMaps("default").List(ctx, metav1.ListOptions{})
+
+	exp := []string{"test get  configmaps default Not Found", "test list  configmaps default OK"}
+	if len(tt.requests) != 2 || tt.requests[0] != exp[0] || tt.requests[1] != exp[1] {
+		t.Error("Unexpected requests", tt.requests)
+	}
+	if tt.waits != 2 {
+		t.Error("Expected rate limiter waits", tt.waits)
+	}
+}
+
+func TestRequestInfo(t *testing.T) {
+	for u, exp := range map[string]string{
+		"GET /apis/apps/v1/namespaces/ns/deployments/d/scale": "get apps deployments/scale ns",
+		"GET /api/v1/pods?watch=true":                         "watch  pods ",
+		"DELETE /api/v1/namespaces/ns/secrets":                "deletecollection  secrets ns",
+		"POST /api/v1/namespaces":                             "create  namespaces ",
+		"GET /version":                                        "get   ",
+	} {
+		m, p, _ := strings.Cut(u, " ")
+		req, _ := http.NewRequest(m, "https://example.com"+p, nil)
+		ri := requestInfo(req)
+		if got := ri.Verb + " " + ri.Group + " " + ri.Resource + " " + ri.Namespace; got != exp {
+			t.Error("Unexpected info", u, got)
+		}
+	}
+}